@@ -21,24 +21,40 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"math/rand"
+	"mime"
+	"mime/multipart"
+	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
 	log "github.com/sirupsen/logrus"
 )
 
+// Interval between the checks of the status of a pending copy
+const azureCopyPollInterval = 100 * time.Millisecond
+
+// Maximum number of subrequests of a blob batch
+const azureMaxBatchSize = 256
+
 type AzureUploader struct {
 	ContainerUrl azblob.ContainerURL
 	ServiceUrl   azblob.ServiceURL
 	UseMultipart bool
+	// Used to send the blob batches, which the SDK has no API for
+	Credential *azblob.SharedKeyCredential
+	Pipeline   pipeline.Pipeline
 }
 
 func NewAzureUploader(access_key, secret_key, url_host, region string) *AzureUploader {
@@ -60,6 +76,8 @@ func NewAzureUploader(access_key, secret_key, url_host, region string) *AzureUpl
 
 	return &AzureUploader{
 		ServiceUrl: serviceURL,
+		Credential: credential,
+		Pipeline:   p,
 	}
 }
 
@@ -79,6 +97,41 @@ func (u *AzureUploader) Prepare(bucket string) error {
 	return nil
 }
 
+func (u *AzureUploader) DoCopy(ctx context.Context, srcID, dstID int) (result TransferResult) {
+	srcKey := fmt.Sprintf("%s-%d", objPrefix, srcID)
+	dstKey := fmt.Sprintf("%s-%d", objPrefix, dstID)
+	srcURL := u.ContainerUrl.NewBlockBlobURL(srcKey).URL()
+	dstBlobURL := u.ContainerUrl.NewBlockBlobURL(dstKey)
+
+	start, err := dstBlobURL.StartCopyFromURL(ctx, srcURL, azblob.Metadata{},
+		azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{})
+	if err != nil {
+		result.Error = fmt.Errorf("error copying object %s to %s: %v", srcKey, dstKey, err)
+		return
+	}
+
+	// The copy is asynchronous, wait for it to complete
+	status := start.CopyStatus()
+	for status == azblob.CopyStatusPending {
+		if err := sleepContext(ctx, azureCopyPollInterval); err != nil {
+			result.Error = fmt.Errorf("error copying object %s to %s: %v", srcKey, dstKey, err)
+			return
+		}
+
+		props, err := dstBlobURL.GetProperties(ctx, azblob.BlobAccessConditions{})
+		if err != nil {
+			result.Error = fmt.Errorf("error copying object %s to %s: %v", srcKey, dstKey, err)
+			return
+		}
+		status = props.CopyStatus()
+	}
+
+	if status != azblob.CopyStatusSuccess {
+		result.Error = fmt.Errorf("error copying object %s to %s: copy status %s", srcKey, dstKey, status)
+	}
+	return
+}
+
 func (u *AzureUploader) DoDelete(ctx context.Context, id int) error {
 	key := fmt.Sprintf("%s-%d", objPrefix, id)
 	blobURL := u.ContainerUrl.NewBlockBlobURL(key)
@@ -93,11 +146,129 @@ func (u *AzureUploader) DoDelete(ctx context.Context, id int) error {
 	return err
 }
 
-func (u *AzureUploader) DoDownload(ctx context.Context, id int) (result TransferResult) {
+// DoBatchDelete deletes the blobs with a blob batch request, each subrequest
+// being signed like a standalone request
+func (u *AzureUploader) DoBatchDelete(ctx context.Context, ids []int) (results []TransferResult) {
+	results = make([]TransferResult, len(ids))
+	for i, id := range ids {
+		results[i].Id = id
+	}
+
+	if err := u.batchDelete(ctx, ids, results); err != nil {
+		err = fmt.Errorf("error deleting %d objects: %v", len(ids), err)
+		log.Error(err)
+		for i := range results {
+			results[i].Error = err
+		}
+	}
+	return
+}
+
+// batchDelete sends the batch, setting the error of the results of the
+// blobs which could not be deleted
+func (u *AzureUploader) batchDelete(ctx context.Context, ids []int, results []TransferResult) error {
+	boundary := fmt.Sprintf("batch_%016x", rand.Uint64())
+	date := time.Now().UTC().Format(http.TimeFormat)
+	sign := u.Credential.New(pipeline.PolicyFunc(
+		func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			return nil, nil
+		}), nil)
+
+	var body bytes.Buffer
+	for i, id := range ids {
+		blobURL := u.ContainerUrl.NewBlockBlobURL(fmt.Sprintf("%s-%d", objPrefix, id)).URL()
+		sub, err := pipeline.NewRequest(http.MethodDelete, blobURL, nil)
+		if err != nil {
+			return err
+		}
+		sub.Header.Set("x-ms-date", date)
+		sub.Header.Set("Content-Length", "0")
+		if _, err := sign.Do(ctx, sub); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&body, "--%s\r\nContent-Type: application/http\r\nContent-Transfer-Encoding: binary\r\n"+
+			"Content-ID: %d\r\n\r\n", boundary, i)
+		fmt.Fprintf(&body, "DELETE %s HTTP/1.1\r\n", blobURL.EscapedPath())
+		if err := sub.Header.Write(&body); err != nil {
+			return err
+		}
+		body.WriteString("\r\n")
+	}
+	fmt.Fprintf(&body, "--%s--\r\n", boundary)
+
+	batchURL := u.ServiceUrl.URL()
+	batchURL.RawQuery = "comp=batch"
+	request, err := pipeline.NewRequest(http.MethodPost, batchURL, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "multipart/mixed; boundary="+boundary)
+	request.Header.Set("x-ms-version", azblob.ServiceVersion)
+
+	response, err := u.Pipeline.Do(ctx, nil, request)
+	if err != nil {
+		return err
+	}
+	resp := response.Response()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("batch request failed: %s", resp.Status)
+	}
+
+	// One response per subrequest, identified by its Content-ID
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	answered := make([]bool, len(ids))
+	parts := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		i, err := strconv.Atoi(part.Header.Get("Content-ID"))
+		if err != nil || i < 0 || i >= len(ids) {
+			return fmt.Errorf("invalid Content-ID %q", part.Header.Get("Content-ID"))
+		}
+		// The line ending the headers is part of the boundary which follows
+		sub, err := http.ReadResponse(bufio.NewReader(io.MultiReader(part, strings.NewReader("\r\n"))), nil)
+		if err != nil {
+			return err
+		}
+		_ = sub.Body.Close()
+
+		answered[i] = true
+		if sub.StatusCode != http.StatusAccepted {
+			results[i].Error = fmt.Errorf("error deleting object %s-%d: %s %s",
+				objPrefix, ids[i], sub.Status, sub.Header.Get("x-ms-error-code"))
+		}
+	}
+
+	for i, ok := range answered {
+		if !ok {
+			results[i].Error = fmt.Errorf("error deleting object %s-%d: no response in the batch", objPrefix, ids[i])
+		}
+	}
+	return nil
+}
+
+func (u *AzureUploader) DoDownload(ctx context.Context, id int, rng byteRange) (result TransferResult) {
 	key := fmt.Sprintf("%s-%d", objPrefix, id)
 	blobURL := u.ContainerUrl.NewBlockBlobURL(key)
 
-	get, err := blobURL.Download(ctx, 0, 0,
+	// A count of 0 downloads the whole blob
+	var offset, count int64
+	if !rng.whole {
+		offset, count = int64(rng.Offset), int64(rng.Length)
+	}
+
+	get, err := blobURL.Download(ctx, offset, count,
 		azblob.BlobAccessConditions{}, false)
 
 	if err != nil {
@@ -112,17 +283,41 @@ func (u *AzureUploader) DoDownload(ctx context.Context, id int) (result Transfer
 	reader := get.Body(azblob.RetryReaderOptions{})
 
 	// Receive response
-	copied, err := io.Copy(ioutil.Discard, reader)
+	result.Error = receiveObject(id, rng, reader)
 	_ = reader.Close()
 
+	return
+}
+
+func (u *AzureUploader) DoHead(ctx context.Context, id int) (result TransferResult) {
+	key := fmt.Sprintf("%s-%d", objPrefix, id)
+	blobURL := u.ContainerUrl.NewBlockBlobURL(key)
+
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{})
 	if err != nil {
-		result.Error = fmt.Errorf("error receiving response %v", err.Error())
+		result.Error = fmt.Errorf("error reading metadata of object %s: %v", key, err)
 		return
 	}
 
-	if uint64(copied) != object_size {
-		result.Error = fmt.Errorf("wrong response size")
-		return
+	result.Error = checkObjectSize(id, props.ContentLength())
+	return
+}
+
+func (u *AzureUploader) DoList(ctx context.Context, prefix string) (result TransferResult) {
+	options := azblob.ListBlobsSegmentOptions{
+		Prefix:     prefix,
+		MaxResults: int32(listPageSize),
+	}
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		list, err := u.ContainerUrl.ListBlobsFlatSegment(ctx, marker, options)
+		if err != nil {
+			result.Error = fmt.Errorf("error listing objects %s: %v", prefix, err)
+			return
+		}
+
+		result.Objects += len(list.Segment.BlobItems)
+		marker = list.NextMarker
 	}
 
 	return
@@ -153,21 +348,26 @@ func (u *AzureUploader) DoUpload(ctx context.Context, id int, data io.ReadSeeker
 		return
 	}
 
-	base64BlockIDs := make([]string, 0, object_size/multipartPartSize+1)
+	size := objectSize(id)
+	base64BlockIDs := make([]string, 0, size/multipartPartSize+1)
 	blockIdx := 0
-	for sent := uint64(0); sent < object_size; {
+	for sent := uint64(0); sent < size; {
 		partEnd := sent + multipartPartSize
 
-		if partEnd > object_size {
-			partEnd = object_size
+		if partEnd > size {
+			partEnd = size
 		}
 
 		base64BlockIDs = append(base64BlockIDs, blockIDIntToBase64(blockIdx))
-		part := object_data[sent:partEnd]
+		part, err := payloadSection(data, int64(sent), int64(partEnd-sent))
+		if err != nil {
+			result.Error = fmt.Errorf("error reading part for %s: %v", key, err)
+			return
+		}
 
 		// log.Infof("loading block %d", blockIdx)
-		_, err := blobURL.StageBlock(ctx, base64BlockIDs[blockIdx],
-			bytes.NewReader(part), azblob.LeaseAccessConditions{},
+		_, err = blobURL.StageBlock(ctx, base64BlockIDs[blockIdx],
+			part, azblob.LeaseAccessConditions{},
 			nil)
 
 		if err != nil {
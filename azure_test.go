@@ -0,0 +1,117 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// TestAzureBatchDelete checks the blob batch sent by DoBatchDelete against a
+// server answering that one of the blobs does not exist
+func TestAzureBatchDelete(t *testing.T) {
+	var u *AzureUploader
+	var mu sync.Mutex
+	var errors []string
+	addError := func(format string, args ...interface{}) {
+		mu.Lock()
+		errors = append(errors, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if r.Method != http.MethodPost || r.URL.Query().Get("comp") != "batch" || mediaType != "multipart/mixed" {
+			addError("unexpected request %s %s %s", r.Method, r.URL, mediaType)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "multipart/mixed; boundary=batchresponse")
+		w.WriteHeader(http.StatusAccepted)
+
+		parts := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := parts.NextPart()
+			if err != nil {
+				break
+			}
+			sub, err := http.ReadRequest(bufio.NewReader(io.MultiReader(part, strings.NewReader("\r\n"))))
+			if err != nil {
+				addError("%v", err)
+				break
+			}
+
+			// Sign the subrequest again, as the service does
+			check, _ := pipeline.NewRequest(sub.Method, *r.URL, nil)
+			check.URL.Path, check.URL.RawQuery = sub.URL.Path, ""
+			check.Header = sub.Header.Clone()
+			check.Header.Del("Authorization")
+			_, _ = u.Credential.New(pipeline.PolicyFunc(
+				func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+					return nil, nil
+				}), nil).Do(context.Background(), check)
+			if sub.Method != http.MethodDelete || check.Header.Get("Authorization") != sub.Header.Get("Authorization") {
+				addError("bad subrequest %s %s", sub.Method, sub.URL)
+			}
+
+			status := "202 Accepted"
+			if sub.URL.Path == "/test/Test-2" {
+				status = "404 The specified blob does not exist."
+			}
+			fmt.Fprintf(w, "--batchresponse\r\nContent-Type: application/http\r\nContent-ID: %s\r\n\r\n"+
+				"HTTP/1.1 %s\r\nContent-Length: 0\r\n\r\n", part.Header.Get("Content-ID"), status)
+		}
+		fmt.Fprint(w, "--batchresponse--\r\n")
+	}))
+	defer ts.Close()
+
+	u = NewAzureUploader("account", base64.StdEncoding.EncodeToString([]byte("secret")), ts.URL, "")
+	u.ContainerUrl = u.ServiceUrl.NewContainerURL("test")
+	objPrefix = "Test"
+
+	results := u.DoBatchDelete(context.Background(), []int{1, 2, 3})
+	mu.Lock()
+	defer mu.Unlock()
+	for _, e := range errors {
+		t.Error(e)
+	}
+	if len(results) != 3 {
+		t.Fatalf("%d results, expected 3", len(results))
+	}
+	for _, r := range results {
+		if (r.Error != nil) != (r.Id == 2) {
+			t.Errorf("object %d: %v", r.Id, r.Error)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// phaseStats holds the statistics of a phase compared between two runs,
+// averaged over the loops of each run
+type phaseStats struct {
+	Operation  string
+	Threads    int
+	ObjectSize uint64
+	MBps       float64
+	OpsPerSec  float64
+	// Percentage of the requests which failed
+	ErrorRate float64
+	P50       float64
+	P90       float64
+	P99       float64
+}
+
+type phaseKey struct {
+	operation  string
+	threads    int
+	objectSize uint64
+}
+
+// comparedMetric is a column of the comparison, with whether a higher
+// value is better
+type comparedMetric struct {
+	name           string
+	value          func(phaseStats) float64
+	higherIsBetter bool
+}
+
+var comparedMetrics = []comparedMetric{
+	{"MBps", func(s phaseStats) float64 { return s.MBps }, true},
+	{"Ops/s", func(s phaseStats) float64 { return s.OpsPerSec }, true},
+	{"p50", func(s phaseStats) float64 { return s.P50 }, false},
+	{"p90", func(s phaseStats) float64 { return s.P90 }, false},
+	{"p99", func(s phaseStats) float64 { return s.P99 }, false},
+}
+
+// runCompare implements the compare subcommand, comparing the report of a
+// run to the one of a baseline run. It exits with status 1 when a phase
+// regressed by more than the thresholds.
+func runCompare(args []string) {
+	var threshold, errorThreshold float64
+
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	flags.Float64Var(&threshold, "threshold", 10, "percentage by which the throughput may drop, or the latencies grow, before failing")
+	flags.Float64Var(&errorThreshold, "error-threshold", 1, "percentage points by which the error rate may grow before failing")
+	flags.Usage = func() {
+		fmt.Println("usage: ./rs-benchmark compare [OPTIONS] BASELINE CURRENT")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		fmt.Println("Unable to parse flags")
+		printHelp()
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if threshold < 0 || errorThreshold < 0 {
+		fmt.Println("The thresholds must be positive")
+		printHelp()
+	}
+
+	baselinePath, currentPath := flags.Arg(0), flags.Arg(1)
+	baseline, err := readReport(baselinePath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	current, err := readReport(currentPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	fmt.Printf("Comparing %s to the baseline %s\n", currentPath, baselinePath)
+	fmt.Printf("Regressions: throughput -%g%%, latency +%g%%, error rate +%g points\n\n", threshold, threshold, errorThreshold)
+
+	baselineKeys, baselineStats := reportPhaseStats(baseline)
+	currentKeys, currentStats := reportPhaseStats(current)
+	if !comparePhases(baselineKeys, baselineStats, currentKeys, currentStats, threshold, errorThreshold) {
+		fmt.Println("\nRegression detected.")
+		os.Exit(1)
+	}
+	fmt.Println("\nNo regression.")
+}
+
+// readReport reads a report written with -output, in the format given by
+// the file extension
+func readReport(path string) (*BenchmarkReport, error) {
+	format, _ := reportFormat(path, "")
+
+	var report BenchmarkReport
+	switch format {
+	case "csv":
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := readReportCSV(csv.NewReader(f), &report); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+	default:
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+	}
+	return &report, nil
+}
+
+// readReportCSV reads the phases of the rows written by writeReportCSV
+func readReportCSV(r *csv.Reader, report *BenchmarkReport) error {
+	rows, err := r.ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("empty file")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[name] = i
+	}
+	for _, name := range []string{"loop", "operation", "threads", "object_size", "successful", "failed",
+		"mbps", "ops_per_sec", "lat_p50", "lat_p90", "lat_p99"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("missing column %s", name)
+		}
+	}
+
+	for n, row := range rows[1:] {
+		var err error
+		integer := func(name string) int {
+			v, e := strconv.Atoi(row[columns[name]])
+			if e != nil && err == nil {
+				err = fmt.Errorf("line %d: invalid %s: %v", n+2, name, e)
+			}
+			return v
+		}
+		float := func(name string) float64 {
+			v, e := strconv.ParseFloat(row[columns[name]], 64)
+			if e != nil && err == nil {
+				err = fmt.Errorf("line %d: invalid %s: %v", n+2, name, e)
+			}
+			return v
+		}
+
+		loop := integer("loop")
+		phase := PhaseResult{
+			Operation:  row[columns["operation"]],
+			Threads:    integer("threads"),
+			ObjectSize: uint64(integer("object_size")),
+			Successful: integer("successful"),
+			Failed:     integer("failed"),
+			MBps:       float("mbps"),
+			OpsPerSec:  float("ops_per_sec"),
+			Latency: LatencyStats{
+				P50: float("lat_p50"),
+				P90: float("lat_p90"),
+				P99: float("lat_p99"),
+			},
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(report.Loops) == 0 || report.Loops[len(report.Loops)-1].Loop != loop {
+			report.Loops = append(report.Loops, LoopResult{Loop: loop})
+		}
+		l := &report.Loops[len(report.Loops)-1]
+		l.Phases = append(l.Phases, phase)
+	}
+	return nil
+}
+
+// reportPhaseStats averages the phases of the loops of a report, per
+// operation, thread count and object size, in the order they ran
+func reportPhaseStats(report *BenchmarkReport) ([]phaseKey, map[phaseKey]phaseStats) {
+	var keys []phaseKey
+	stats := make(map[phaseKey]phaseStats)
+	counts := make(map[phaseKey]int)
+	requests := make(map[phaseKey]int)
+
+	for _, loop := range report.Loops {
+		for _, phase := range loop.Phases {
+			k := phaseKey{phase.Operation, phase.Threads, phase.ObjectSize}
+			s, ok := stats[k]
+			if !ok {
+				keys = append(keys, k)
+				s = phaseStats{Operation: phase.Operation, Threads: phase.Threads, ObjectSize: phase.ObjectSize}
+			}
+			s.MBps += phase.MBps
+			s.OpsPerSec += phase.OpsPerSec
+			s.ErrorRate += float64(phase.Failed)
+			s.P50 += phase.Latency.P50
+			s.P90 += phase.Latency.P90
+			s.P99 += phase.Latency.P99
+			stats[k] = s
+			counts[k]++
+			requests[k] += phase.Successful + phase.Failed
+		}
+	}
+
+	for _, k := range keys {
+		s, n := stats[k], float64(counts[k])
+		s.MBps /= n
+		s.OpsPerSec /= n
+		if requests[k] > 0 {
+			s.ErrorRate = 100 * s.ErrorRate / float64(requests[k])
+		}
+		s.P50 /= n
+		s.P90 /= n
+		s.P99 /= n
+		stats[k] = s
+	}
+	return keys, stats
+}
+
+// comparePhases prints the baseline, current and relative values of every
+// phase of the baseline, and returns false if one of them regressed
+func comparePhases(baselineKeys []phaseKey, baseline map[phaseKey]phaseStats,
+	currentKeys []phaseKey, current map[phaseKey]phaseStats, threshold, errorThreshold float64) bool {
+	ok := true
+
+	fmt.Printf("%-9s%-6s%-11s%-10s", "Threads", "Size", "Operation", "Run")
+	for _, m := range comparedMetrics {
+		name := m.name
+		if !m.higherIsBetter {
+			name += "(ms)"
+		}
+		fmt.Printf("%-10s", name)
+	}
+	fmt.Printf("%-10s\n", "Errors(%)")
+
+	for _, k := range baselineKeys {
+		b := baseline[k]
+		size := "var"
+		if k.objectSize > 0 {
+			size = bytefmt.ByteSize(k.objectSize)
+		}
+		fmt.Printf("%-9d%-6s%-11s", k.threads, size, k.operation)
+		printPhaseStats("baseline", b)
+
+		c, found := current[k]
+		if !found {
+			fmt.Printf("%-26s%-10s<- regression: missing phase\n", "", "current")
+			ok = false
+			continue
+		}
+		fmt.Printf("%-26s", "")
+		printPhaseStats("current", c)
+
+		var regressed []string
+		fmt.Printf("%-26s%-10s", "", "delta")
+		for _, m := range comparedMetrics {
+			bv, cv := m.value(b), m.value(c)
+			if bv == 0 {
+				fmt.Printf("%-10s", "n/a")
+				continue
+			}
+			delta := 100 * (cv - bv) / bv
+			fmt.Printf("%-10s", fmt.Sprintf("%+.1f%%", delta))
+			if (m.higherIsBetter && delta < -threshold) || (!m.higherIsBetter && delta > threshold) {
+				regressed = append(regressed, m.name)
+			}
+		}
+		errorDelta := c.ErrorRate - b.ErrorRate
+		fmt.Printf("%-10s", fmt.Sprintf("%+.2f", errorDelta))
+		if errorDelta > errorThreshold {
+			regressed = append(regressed, "errors")
+		}
+
+		if len(regressed) > 0 {
+			fmt.Printf("<- regression: %s", strings.Join(regressed, ", "))
+			ok = false
+		}
+		fmt.Println("")
+	}
+
+	for _, k := range currentKeys {
+		if _, found := baseline[k]; !found {
+			size := "var"
+			if k.objectSize > 0 {
+				size = bytefmt.ByteSize(k.objectSize)
+			}
+			fmt.Printf("%-9d%-6s%-11s", k.threads, size, k.operation)
+			printPhaseStats("new", current[k])
+		}
+	}
+	return ok
+}
+
+func printPhaseStats(run string, s phaseStats) {
+	fmt.Printf("%-10s", run)
+	for _, m := range comparedMetrics {
+		v := m.value(s)
+		if !m.higherIsBetter {
+			// Latencies are in seconds
+			v *= 1000
+		}
+		fmt.Printf("%-10.2f", v)
+	}
+	fmt.Printf("%-10.2f\n", s.ErrorRate)
+}
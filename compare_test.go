@@ -0,0 +1,122 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCompareProcess is not a test: it runs the compare subcommand when
+// started by runTestCompare, which checks its exit status
+func TestCompareProcess(t *testing.T) {
+	args := os.Getenv("RS_BENCHMARK_TEST_COMPARE")
+	if args == "" {
+		return
+	}
+	runCompare(strings.Split(args, "\n"))
+	os.Exit(0)
+}
+
+// runTestCompare runs the compare subcommand and returns its exit status
+func runTestCompare(t *testing.T, args ...string) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCompareProcess$")
+	cmd.Env = append(os.Environ(), "RS_BENCHMARK_TEST_COMPARE="+strings.Join(args, "\n"))
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0
+}
+
+// writeTestReport writes a report of a single loop with a PUT and a GET
+// phase, the GET phase having the given throughput and failures
+func writeTestReport(t *testing.T, dir, name string, getMBps float64, getFailed int) string {
+	report := BenchmarkReport{Loops: []LoopResult{{Loop: 1, Phases: []PhaseResult{
+		{Operation: "PUT", Threads: 8, ObjectSize: 1024 * 1024, Successful: 1000, MBps: 100, OpsPerSec: 100,
+			Latency: LatencyStats{P50: 0.01, P90: 0.02, P99: 0.05}},
+		{Operation: "GET", Threads: 8, ObjectSize: 1024 * 1024, Successful: 1000 - getFailed, Failed: getFailed,
+			MBps: getMBps, OpsPerSec: getMBps, Latency: LatencyStats{P50: 0.01, P90: 0.02, P99: 0.05}},
+	}}}}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCompare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rs-benchmark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	baseline := writeTestReport(t, dir, "baseline.json", 200, 0)
+	same := writeTestReport(t, dir, "same.json", 195, 0)
+	slower := writeTestReport(t, dir, "slower.json", 160, 0)
+	failing := writeTestReport(t, dir, "failing.json", 200, 50)
+
+	// Without its GET phase
+	data, err := ioutil.ReadFile(baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.json")
+	if err := ioutil.WriteFile(missing, []byte(strings.Replace(string(data), `"GET"`, `"HEAD"`, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		args   []string
+		status int
+	}{
+		{[]string{baseline, same}, 0},
+		// Throughput down by 20%
+		{[]string{baseline, slower}, 1},
+		{[]string{"-threshold", "25", baseline, slower}, 0},
+		// Error rate up by 5 points
+		{[]string{baseline, failing}, 1},
+		{[]string{"-error-threshold", "10", baseline, failing}, 0},
+		{[]string{baseline, missing}, 1},
+		// An improvement is no regression
+		{[]string{slower, baseline}, 0},
+		{[]string{baseline, filepath.Join(dir, "none.json")}, 2},
+		{[]string{baseline}, 2},
+	} {
+		if status := runTestCompare(t, test.args...); status != test.status {
+			t.Errorf("compare %v: exit status %d, expected %d", test.args, status, test.status)
+		}
+	}
+}
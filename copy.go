@@ -0,0 +1,86 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+var copyEnabled bool
+
+// runCopyPhase measures server-side copies of the uploaded objects to new
+// keys. Only the DELETE phase can follow it: the copies have the content of
+// their source, not the one expected for their own id, and they are only
+// added to successFulUploadsIDs to be deleted.
+func runCopyPhase() phaseRun {
+	// Copies get the ids following the ones of the uploaded objects
+	firstCopyID := nextObjectID()
+
+	// The workers still running after the deadline must not see the copies
+	sources := append([]int(nil), successFulUploadsIDs...)
+
+	indexes := make(chan int, threads)
+	res := make(chan TransferResult, threads)
+
+	ctx, cancelRemainingCopies := context.WithCancel(context.Background())
+	for n := 0; n <= threads; n++ {
+		go runCopy(ctx, sources, firstCopyID, indexes, res)
+	}
+
+	recorder := startIntervalRecorder("COPY", warmup)
+	startTime := time.Now().Add(warmup)
+	copyResults := runAndCollectResults(indexes, res, recorder)
+	cancelRemainingCopies()
+	checkSchedule("COPY")
+	copyTime := time.Now().Sub(startTime).Seconds()
+
+	for _, r := range copyResults {
+		if r.Error == nil {
+			successFulUploadsIDs = append(successFulUploadsIDs, firstCopyID+r.Id)
+		}
+	}
+
+	return phaseRun{
+		Operation: "COPY",
+		Time:      copyTime,
+		Results:   measuredResults(copyResults, startTime),
+		Intervals: recorder.stop(),
+	}
+}
+
+func runCopy(ctx context.Context, sources []int, firstCopyID int, indexes chan int, res chan TransferResult) {
+	for id := range indexes {
+		srcID := sources[id%len(sources)]
+
+		startTime := waitTurn(ctx, objectSize(srcID))
+		r := client.DoCopy(ctx, srcID, firstCopyID+id)
+
+		r.Start = startTime
+		r.Duration = time.Now().Sub(startTime)
+		r.Id = id
+		r.Bytes = objectSize(srcID)
+		r.ObjectSize = r.Bytes
+
+		logTransferError(r.Error)
+		res <- r
+	}
+}
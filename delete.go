@@ -0,0 +1,114 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Number of objects deleted by each request, batches need a client
+// implementing BatchDeleter: the other protocols reject values above 1
+var deleteBatchSize = 1
+
+// Maximum number of objects in a batch, the limit of S3
+const maxDeleteBatchSize = 1000
+
+// runDeletePhase deletes all the objects of the loop. Unlike the other
+// phases it is not limited in time, it lasts until every object is deleted.
+// Each object gets its own result, carrying the latency of the request which
+// deleted it.
+func runDeletePhase() phaseRun {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	results := make([]TransferResult, 0, len(successFulUploadsIDs))
+
+	batches := make(chan []int)
+	wg := sync.WaitGroup{}
+	wg.Add(threads)
+
+	recorder := startIntervalRecorder("DELETE", 0)
+	startTime := time.Now()
+	for n := 0; n < threads; n++ {
+		go func() {
+			defer wg.Done()
+
+			for batch := range batches {
+				r := deleteObjects(ctx, batch)
+
+				mu.Lock()
+				results = append(results, r...)
+				mu.Unlock()
+
+				for _, res := range r {
+					recorder.add(res)
+				}
+			}
+		}()
+	}
+
+	progress := 0
+	for i := 0; i < len(successFulUploadsIDs); i += deleteBatchSize {
+		end := i + deleteBatchSize
+		if end > len(successFulUploadsIDs) {
+			end = len(successFulUploadsIDs)
+		}
+		batches <- successFulUploadsIDs[i:end]
+
+		if end/1000 > progress {
+			progress = end / 1000
+			fmt.Printf("%d deletes completed\n", progress*1000)
+		}
+	}
+	close(batches)
+	wg.Wait()
+	deleteTime := time.Now().Sub(startTime).Seconds()
+
+	return phaseRun{
+		Operation: "DELETE",
+		Time:      deleteTime,
+		Results:   results,
+		Intervals: recorder.stop(),
+	}
+}
+
+func deleteObjects(ctx context.Context, ids []int) []TransferResult {
+	startTime := time.Now()
+
+	var results []TransferResult
+	if batchDeleter, ok := client.(BatchDeleter); ok && len(ids) > 1 {
+		results = batchDeleter.DoBatchDelete(ctx, ids)
+	} else {
+		for _, id := range ids {
+			results = append(results, TransferResult{Id: id, Error: client.DoDelete(ctx, id)})
+		}
+	}
+
+	duration := time.Now().Sub(startTime)
+	for i := range results {
+		results[i].Start = startTime
+		results[i].Duration = duration
+	}
+	return results
+}
@@ -0,0 +1,417 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultWorkerAddr = "127.0.0.1:7070"
+
+// Environment variable holding the token shared by the workers and the
+// coordinator, which keeps it off the command line
+const workerTokenEnv = "RS_BENCHMARK_TOKEN"
+
+// Worker runs the phases of the loops requested by a coordinator, which
+// calls its methods through net/rpc
+type Worker struct {
+	// A phase at a time, even with several coordinators
+	mu         sync.Mutex
+	token      string
+	configured bool
+	// Index of the sweep point applied
+	point int
+}
+
+// WorkerConfig is the configuration sent by the coordinator
+type WorkerConfig struct {
+	Token string
+	// Command line options of the coordinator
+	Args []string
+	// Content of the -config workload file of the coordinator, if any
+	Workload []byte
+	// Index of the worker, which keeps the objects of the workers apart
+	Index int
+}
+
+// WorkerPhase asks a worker to run a phase
+type WorkerPhase struct {
+	Token string
+	// Index of the sweep point to run the phase with
+	Point int
+	// Index of the phase in the loop
+	Step int
+}
+
+// WorkerPhaseRun is a phaseRun sent back to the coordinator
+type WorkerPhaseRun struct {
+	Operation  string
+	Threads    int
+	ObjectSize uint64
+	Time       float64
+	Results    []WorkerResult
+	Intervals  []IntervalResult
+}
+
+// WorkerResult is a TransferResult sent back to the coordinator. The error
+// is sent as a string since gob can't encode error values.
+type WorkerResult struct {
+	Id         int
+	Bytes      uint64
+	ObjectSize uint64
+	Objects    int
+	Start      time.Time
+	Duration   time.Duration
+	Error      string
+	Corrupted  bool
+}
+
+// runWorker implements the worker subcommand, serving coordinators until
+// the process is killed
+func runWorker(args []string) {
+	var listenAddr, metricsAddr, token string
+
+	flags := flag.NewFlagSet("worker", flag.ExitOnError)
+	flags.StringVar(&listenAddr, "listen", defaultWorkerAddr, "address to wait for coordinators on, e.g. :7070 for every interface")
+	flags.StringVar(&metricsAddr, "metrics-listen", "", "serve Prometheus metrics on this address, e.g. :9100")
+	flags.StringVar(&token, "token", os.Getenv(workerTokenEnv), "token the coordinators must send (default: $"+workerTokenEnv+")")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println("Unable to parse flags")
+		printHelp()
+	}
+	if token == "" {
+		fmt.Printf("Missing token: the worker requires a -token or $%s shared with the coordinators.\n", workerTokenEnv)
+		printHelp()
+	}
+	if metricsAddr != "" {
+		if err := startMetricsServer(metricsAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	server := rpc.NewServer()
+	if err := server.Register(&Worker{token: token}); err != nil {
+		log.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		log.Fatalf("error listening on %s: %v", listenAddr, err)
+	}
+	fmt.Printf("Waiting for a coordinator on %s\n", l.Addr())
+	server.Accept(l)
+}
+
+// checkToken rejects the requests of coordinators without the token
+func (w *Worker) checkToken(token string) error {
+	if subtle.ConstantTimeCompare([]byte(token), []byte(w.token)) != 1 {
+		return errors.New("invalid worker token")
+	}
+	return nil
+}
+
+// Configure sets the worker up with the options of the coordinator. Invalid
+// options are returned as an error, the worker then waiting to be configured
+// again.
+func (w *Worker) Configure(config WorkerConfig, reply *bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.checkToken(config.Token); err != nil {
+		return err
+	}
+
+	// The workload file is the one of the coordinator, the worker never
+	// reads its own files
+	readWorkload := func(string) ([]byte, error) {
+		if config.Workload == nil {
+			return nil, errors.New("no workload file sent by the coordinator")
+		}
+		return config.Workload, nil
+	}
+
+	fmt.Printf("\nConfigured by a coordinator as worker %d\n", config.Index)
+	w.configured = false
+	if _, err := parseOptions(config.Args, flag.ContinueOnError, readWorkload); err != nil {
+		fmt.Printf("Invalid options: %v\n", err)
+		return err
+	}
+	objPrefix = fmt.Sprintf("%s-w%d", objPrefix, config.Index)
+
+	w.configured = true
+	w.point = -1
+	*reply = true
+	return nil
+}
+
+// RunPhase runs a phase and returns the results of all its requests
+func (w *Worker) RunPhase(phase WorkerPhase, reply *[]WorkerPhaseRun) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.checkToken(phase.Token); err != nil {
+		return err
+	}
+	if !w.configured {
+		return errors.New("worker not configured")
+	}
+
+	points := sweepPoints()
+	if phase.Point < 0 || phase.Point >= len(points) {
+		return fmt.Errorf("unknown sweep point %d", phase.Point)
+	}
+	// Applying the point again would reset the sizes changed by the
+	// previous phases of the loop
+	if phase.Point != w.point && points[phase.Point].apply != nil {
+		points[phase.Point].apply()
+	}
+	w.point = phase.Point
+
+	if phase.Step < 0 || phase.Step >= len(loopPhases()) {
+		return fmt.Errorf("unknown phase %d", phase.Step)
+	}
+
+	for _, run := range runLocalPhase(phase.Step) {
+		*reply = append(*reply, WorkerPhaseRun{
+			Operation:  run.Operation,
+			Threads:    run.Threads,
+			ObjectSize: run.ObjectSize,
+			Time:       run.Time,
+			Results:    toWorkerResults(run.Results),
+			Intervals:  run.Intervals,
+		})
+		fmt.Printf("%s done, %d requests\n", run.Operation, len(run.Results))
+	}
+	return nil
+}
+
+func toWorkerResults(results []TransferResult) []WorkerResult {
+	wr := make([]WorkerResult, len(results))
+	for i, r := range results {
+		wr[i] = WorkerResult{
+			Id:         r.Id,
+			Bytes:      r.Bytes,
+			ObjectSize: r.ObjectSize,
+			Objects:    r.Objects,
+			Start:      r.Start,
+			Duration:   r.Duration,
+			Corrupted:  isCorrupted(r.Error),
+		}
+		if r.Error != nil {
+			wr[i].Error = r.Error.Error()
+		}
+	}
+	return wr
+}
+
+func fromWorkerResults(wr []WorkerResult) []TransferResult {
+	results := make([]TransferResult, len(wr))
+	for i, r := range wr {
+		results[i] = TransferResult{
+			Id:         r.Id,
+			Bytes:      r.Bytes,
+			ObjectSize: r.ObjectSize,
+			Objects:    r.Objects,
+			Start:      r.Start,
+			Duration:   r.Duration,
+		}
+		if r.Corrupted {
+			results[i].Error = &corruptedObjectError{Id: r.Id}
+		} else if r.Error != "" {
+			results[i].Error = errors.New(r.Error)
+		}
+	}
+	return results
+}
+
+// coordinator runs every phase on all the workers at once, and merges their
+// results as if they came from a single process
+type coordinator struct {
+	addrs   []string
+	token   string
+	clients []*rpc.Client
+	// Index of the sweep point being run
+	point int
+}
+
+// newCoordinator connects to the workers and sends them the options and the
+// content of the workload file
+func newCoordinator(addrs []string, token string, args []string, workload []byte) (*coordinator, error) {
+	c := &coordinator{addrs: addrs, token: token}
+
+	for i, addr := range addrs {
+		client, err := rpc.Dial("tcp", addr)
+		if err != nil {
+			c.close()
+			return nil, fmt.Errorf("error connecting to worker %s: %v", addr, err)
+		}
+		c.clients = append(c.clients, client)
+
+		var ok bool
+		if err := client.Call("Worker.Configure", WorkerConfig{Token: token, Args: args, Workload: workload, Index: i}, &ok); err != nil {
+			c.close()
+			return nil, fmt.Errorf("error configuring worker %s: %v", addr, err)
+		}
+	}
+	return c, nil
+}
+
+// close disconnects from the workers, which wait for the next coordinator
+func (c *coordinator) close() {
+	for _, client := range c.clients {
+		_ = client.Close()
+	}
+}
+
+func (c *coordinator) runPhase(step int) []phaseRun {
+	phase := loopPhases()[step].operation
+
+	replies := make([][]WorkerPhaseRun, len(c.clients))
+	errs := make([]error, len(c.clients))
+
+	wg := sync.WaitGroup{}
+	for i, client := range c.clients {
+		wg.Add(1)
+		go func(i int, client *rpc.Client) {
+			defer wg.Done()
+			errs[i] = client.Call("Worker.RunPhase", WorkerPhase{Token: c.token, Point: c.point, Step: step}, &replies[i])
+		}(i, client)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			log.Fatalf("error running %s on worker %s: %v", phase, c.addrs[i], err)
+		}
+	}
+
+	// The phase lasts as long as its slowest worker, and runs with the
+	// threads of all the workers
+	var runs []phaseRun
+	var intervals [][]workerIntervals
+	index := make(map[string]int)
+	for _, reply := range replies {
+		for _, wr := range reply {
+			i, ok := index[wr.Operation]
+			if !ok {
+				i = len(runs)
+				index[wr.Operation] = i
+				runs = append(runs, phaseRun{
+					Operation:  wr.Operation,
+					ObjectSize: wr.ObjectSize,
+				})
+				intervals = append(intervals, nil)
+			}
+
+			if wr.Time > runs[i].Time {
+				runs[i].Time = wr.Time
+			}
+			runs[i].Threads += wr.Threads
+			results := fromWorkerResults(wr.Results)
+			runs[i].Results = append(runs[i].Results, results...)
+			intervals[i] = append(intervals[i], workerIntervals{wr.Intervals, results})
+		}
+	}
+
+	for i := range runs {
+		runs[i].Intervals = mergeWorkerIntervals(intervals[i])
+		if len(runs[i].Intervals) > 0 {
+			printIntervalHeader(runs[i].Operation)
+			for _, interval := range runs[i].Intervals {
+				printInterval(runs[i].Operation, interval)
+			}
+		}
+	}
+
+	// The metrics of the coordinator only get the results of the workers
+	// at the end of the phase
+	for _, run := range runs {
+		for _, r := range run.Results {
+			recordMetrics(run.Operation, r)
+		}
+	}
+	return runs
+}
+
+// workerIntervals holds the intervals of a phase run by a worker, with the
+// results of its requests
+type workerIntervals struct {
+	intervals []IntervalResult
+	results   []TransferResult
+}
+
+// mergeWorkerIntervals merges the intervals of the workers by index. The
+// requests are assigned to the interval they completed in on their worker,
+// and the statistics of each interval are computed over the requests of all
+// the workers.
+func mergeWorkerIntervals(workers []workerIntervals) []IntervalResult {
+	var starts, lengths []float64
+	var completed [][]TransferResult
+	for _, w := range workers {
+		if len(w.intervals) == 0 {
+			continue
+		}
+
+		for i, interval := range w.intervals {
+			if i == len(starts) {
+				starts = append(starts, interval.Start)
+				lengths = append(lengths, 0)
+				completed = append(completed, nil)
+			}
+			if interval.Time > lengths[i] {
+				lengths[i] = interval.Time
+			}
+		}
+
+		for _, r := range w.results {
+			end := r.Start.Add(r.Duration)
+			i := sort.Search(len(w.intervals), func(i int) bool {
+				return end.Before(w.intervals[i].End)
+			})
+			if i == len(w.intervals) {
+				// The last interval is closed when the phase ends
+				i--
+			}
+			completed[i] = append(completed[i], r)
+		}
+	}
+
+	merged := make([]IntervalResult, len(starts))
+	for i := range starts {
+		merged[i] = newIntervalResult(secondsDuration(starts[i]), secondsDuration(lengths[i]), completed[i])
+	}
+	return merged
+}
+
+func secondsDuration(secs float64) time.Duration {
+	return time.Duration(secs * float64(time.Second))
+}
@@ -0,0 +1,146 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+const testWorkerToken = "test-token"
+
+// TestWorkerProcess is not a test: it runs a worker when started by
+// startTestWorker. The workers run in their own process since the options
+// they are configured with are package globals.
+func TestWorkerProcess(t *testing.T) {
+	if os.Getenv("RS_BENCHMARK_TEST_WORKER") != "1" {
+		return
+	}
+	runWorker([]string{"-listen", "127.0.0.1:0", "-token", testWorkerToken})
+}
+
+// startTestWorker starts a worker process and returns its address
+func startTestWorker(t *testing.T) (string, *exec.Cmd) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWorkerProcess$")
+	cmd.Env = append(os.Environ(), "RS_BENCHMARK_TEST_WORKER=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	const waiting = "Waiting for a coordinator on "
+	r := bufio.NewReader(stdout)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			_ = cmd.Process.Kill()
+			t.Fatalf("worker exited: %v", err)
+		}
+		if strings.HasPrefix(line, waiting) {
+			// The worker must not block on its output
+			go func() { _, _ = io.Copy(ioutil.Discard, r) }()
+			return strings.TrimSpace(strings.TrimPrefix(line, waiting)), cmd
+		}
+	}
+}
+
+func TestDistributed(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		addr, cmd := startTestWorker(t)
+		defer func() {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		}()
+		addrs = append(addrs, addr)
+	}
+
+	args := []string{"-protocol", "mem", "-b", "test", "-d", "1", "-t", "2", "-z", "4K",
+		"-interval", "250ms", "-workers", strings.Join(addrs, ","), "-worker-token", testWorkerToken}
+	report := runBenchmark(args, configure(args))
+	reportInterval = 0
+
+	if len(report.Loops) != 1 {
+		t.Fatalf("%d loops, expected 1", len(report.Loops))
+	}
+	for _, phase := range report.Loops[0].Phases {
+		if phase.Successful == 0 || phase.Failed > 0 {
+			t.Errorf("%s: %d successful, %d failed", phase.Operation, phase.Successful, phase.Failed)
+		}
+		if phase.Threads != 4 {
+			t.Errorf("%s: %d threads, expected the 4 threads of the workers", phase.Operation, phase.Threads)
+		}
+		if len(phase.Intervals) == 0 {
+			t.Errorf("%s: no intervals", phase.Operation)
+			continue
+		}
+
+		successful := 0
+		for _, interval := range phase.Intervals {
+			successful += interval.Successful
+		}
+		if successful != phase.Successful {
+			t.Errorf("%s: %d requests in the intervals, expected %d", phase.Operation, successful, phase.Successful)
+		}
+	}
+}
+
+func TestWorkerRejectsConfigurations(t *testing.T) {
+	addr, cmd := startTestWorker(t)
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	args := []string{"-protocol", "mem", "-b", "test", "-z", "4K"}
+	for _, test := range []struct {
+		token string
+		args  []string
+	}{
+		{"wrong-token", args},
+		{testWorkerToken, append(args, "-unknown-flag")},
+		{testWorkerToken, append(args, "-z", "4Q")},
+		{testWorkerToken, append(args, "-prefix", "../Object")},
+		{testWorkerToken, append(args, "-config", "/etc/passwd")},
+		{testWorkerToken, append(args, "-version")},
+	} {
+		coord, err := newCoordinator([]string{addr}, test.token, test.args, nil)
+		if err == nil {
+			coord.close()
+			t.Errorf("token %s, options %v accepted", test.token, test.args)
+		}
+	}
+
+	// The worker survives the rejected configurations
+	coord, err := newCoordinator([]string{addr}, testWorkerToken, args, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coord.close()
+}
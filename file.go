@@ -0,0 +1,303 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Direct I/O requires buffers, offsets and lengths aligned to the logical
+// block size of the device. 4K covers every common device.
+const directIOAlignment = 4096
+
+const fileReadBufferSize = 1024 * 1024
+
+var fileReadBuffers = sync.Pool{
+	New: func() interface{} {
+		return alignedBuffer(fileReadBufferSize)
+	},
+}
+
+// FileUploader stores objects as files in a directory, to compare object
+// storages with the local or network filesystems backing them. Each bucket
+// is a subdirectory of Root.
+type FileUploader struct {
+	Root     string
+	Dir      string
+	Fsync    bool
+	DirectIO bool
+}
+
+func NewFileUploader(root string) *FileUploader {
+	return &FileUploader{
+		Root: root,
+	}
+}
+
+func (u *FileUploader) Prepare(bucket string) error {
+	u.Dir = filepath.Join(u.Root, bucket)
+
+	err := os.MkdirAll(u.Dir, 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create directory %s: %v", u.Dir, err)
+	}
+	return nil
+}
+
+func (u *FileUploader) path(id int) string {
+	return filepath.Join(u.Dir, fmt.Sprintf("%s-%d", objPrefix, id))
+}
+
+func (u *FileUploader) DoCopy(ctx context.Context, srcID, dstID int) (result TransferResult) {
+	srcPath, dstPath := u.path(srcID), u.path(dstID)
+
+	if err := ctx.Err(); err != nil {
+		result.Error = fmt.Errorf("error copying object %s: %v", srcPath, err)
+		return
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		result.Error = fmt.Errorf("error copying object %s: %v", srcPath, err)
+		return
+	}
+	defer src.Close()
+
+	result.Error = u.writeFile(dstPath, src)
+	return
+}
+
+func (u *FileUploader) DoDelete(ctx context.Context, id int) error {
+	path := u.path(id)
+
+	err := os.Remove(path)
+	if err != nil {
+		log.Errorf("Error deleting object %s: %v", path, err)
+	}
+	return err
+}
+
+func (u *FileUploader) DoDownload(ctx context.Context, id int, rng byteRange) (result TransferResult) {
+	path := u.path(id)
+
+	if err := ctx.Err(); err != nil {
+		result.Error = fmt.Errorf("error downloading object %s: %v", path, err)
+		return
+	}
+
+	flags := os.O_RDONLY
+	if u.DirectIO {
+		flags |= directIOFlag
+	}
+
+	f, err := os.OpenFile(path, flags, 0)
+	if err != nil {
+		result.Error = fmt.Errorf("error downloading object %s: %v", path, err)
+		return
+	}
+
+	receiver := newObjectReceiver(id, rng)
+
+	var copied int64
+	if rng.whole {
+		copied, err = readFile(f, receiver)
+	} else {
+		copied, err = readFileRange(f, receiver, int64(rng.Offset), int64(rng.Length))
+	}
+	_ = f.Close()
+
+	if err != nil {
+		result.Error = fmt.Errorf("error reading %s: %v", path, err)
+		return
+	}
+
+	result.Error = receiver.check(copied)
+	return
+}
+
+func (u *FileUploader) DoHead(ctx context.Context, id int) (result TransferResult) {
+	path := u.path(id)
+
+	if err := ctx.Err(); err != nil {
+		result.Error = fmt.Errorf("error reading metadata of object %s: %v", path, err)
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		result.Error = fmt.Errorf("error reading metadata of object %s: %v", path, err)
+		return
+	}
+
+	result.Error = checkObjectSize(id, info.Size())
+	return
+}
+
+func (u *FileUploader) DoList(ctx context.Context, prefix string) (result TransferResult) {
+	dir, err := os.Open(u.Dir)
+	if err != nil {
+		result.Error = fmt.Errorf("error listing objects %s: %v", prefix, err)
+		return
+	}
+	defer dir.Close()
+
+	for ctx.Err() == nil {
+		names, err := dir.Readdirnames(listPageSize)
+		for _, name := range names {
+			// Skip the objects being written
+			if strings.HasPrefix(name, prefix) && !strings.HasSuffix(name, ".tmp") {
+				result.Objects++
+			}
+		}
+
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			result.Error = fmt.Errorf("error listing objects %s: %v", prefix, err)
+			return
+		}
+	}
+
+	result.Error = fmt.Errorf("error listing objects %s: %v", prefix, ctx.Err())
+	return
+}
+
+func (u *FileUploader) DoUpload(ctx context.Context, id int, data io.ReadSeeker) (result TransferResult) {
+	result.Id = id
+
+	path := u.path(id)
+
+	if err := ctx.Err(); err != nil {
+		result.Error = fmt.Errorf("error uploading object %s: %v", path, err)
+		return
+	}
+
+	result.Error = u.writeFile(path, data)
+	return
+}
+
+// writeFile writes to a temporary file and renames it, so that readers
+// never see a partially written object, as on an object storage
+func (u *FileUploader) writeFile(path string, data io.Reader) error {
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error uploading object %s: %v", path, err)
+	}
+
+	_, err = io.Copy(f, data)
+	if err == nil && u.Fsync {
+		err = f.Sync()
+	}
+
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+
+	if err = f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("error closing %s: %v", path, err)
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("error renaming %s: %v", tmpPath, err)
+	}
+
+	return nil
+}
+
+// readFile reads f with an aligned buffer, so that it also works for files
+// opened for direct I/O
+func readFile(f *os.File, w io.Writer) (int64, error) {
+	buf := fileReadBuffers.Get().([]byte)
+	defer fileReadBuffers.Put(buf)
+
+	var copied int64
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return copied, werr
+			}
+			copied += int64(n)
+		}
+
+		if err == io.EOF {
+			return copied, nil
+		}
+		if err != nil {
+			return copied, err
+		}
+	}
+}
+
+// readFileRange reads length bytes of f from offset. The reads start at an
+// aligned offset, as required for direct I/O.
+func readFileRange(f *os.File, w io.Writer, offset, length int64) (int64, error) {
+	start := offset &^ (directIOAlignment - 1)
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	rw := &rangeWriter{w: w, skip: offset - start, remaining: length}
+	buf := fileReadBuffers.Get().([]byte)
+	defer fileReadBuffers.Put(buf)
+
+	for rw.remaining > 0 {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if _, werr := rw.Write(buf[:n]); werr != nil {
+				return length - rw.remaining, werr
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return length - rw.remaining, err
+		}
+	}
+	return length - rw.remaining, nil
+}
+
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+
+	offset := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1))
+	if offset != 0 {
+		offset = directIOAlignment - offset
+	}
+	return buf[offset : offset+size]
+}
@@ -0,0 +1,116 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+func TestFileReadRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rs-benchmark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 10000)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+
+	u := &FileUploader{Fsync: true}
+	path := filepath.Join(dir, "object")
+	if err := u.writeFile(path, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	// Some filesystems, like tmpfs, reject direct I/O
+	flags := os.O_RDONLY
+	if directIOSupported {
+		if f, err := os.OpenFile(path, flags|directIOFlag, 0); err == nil {
+			_ = f.Close()
+			flags |= directIOFlag
+		} else {
+			t.Logf("reading without direct I/O: %v", err)
+		}
+	}
+
+	read := func(offset, length int64, whole bool) ([]byte, int64) {
+		f, err := os.OpenFile(path, flags, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		var buf bytes.Buffer
+		var n int64
+		if whole {
+			n, err = readFile(f, &buf)
+		} else {
+			n, err = readFileRange(f, &buf, offset, length)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes(), n
+	}
+
+	if data, n := read(0, 0, true); n != int64(len(content)) || !bytes.Equal(data, content) {
+		t.Errorf("read %d bytes of the whole file, expected %d", n, len(content))
+	}
+
+	for _, r := range []struct{ offset, length int64 }{
+		{0, 10},
+		{4095, 2},
+		{4096, 4096},
+		{5000, 5000},
+	} {
+		data, n := read(r.offset, r.length, false)
+		if n != r.length || !bytes.Equal(data, content[r.offset:r.offset+r.length]) {
+			t.Errorf("range %d+%d: read %d bytes, not the content of the file", r.offset, r.length, n)
+		}
+	}
+
+	// Ranges past the end are cut short
+	if data, n := read(9990, 100, false); n != 10 || !bytes.Equal(data, content[9990:]) {
+		t.Errorf("range 9990+100: read %d bytes, expected the last 10", n)
+	}
+}
+
+func TestAlignedBuffer(t *testing.T) {
+	for _, size := range []int{1, 4096, 100000} {
+		buf := alignedBuffer(size)
+		if len(buf) != size {
+			t.Errorf("buffer of %d bytes, expected %d", len(buf), size)
+		}
+		if addr := uintptr(unsafe.Pointer(&buf[0])); addr%directIOAlignment != 0 {
+			t.Errorf("buffer of %d bytes at %#x, not aligned", size, addr)
+		}
+	}
+}
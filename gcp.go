@@ -21,16 +21,15 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 
 	gstorage "cloud.google.com/go/storage"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
 )
 
 type GCP struct {
@@ -59,6 +58,17 @@ func (u *GCP) Prepare(bucketName string) error {
 	return nil
 }
 
+func (u *GCP) DoCopy(ctx context.Context, srcID, dstID int) (result TransferResult) {
+	srcKey := fmt.Sprintf("%s-%d", objPrefix, srcID)
+	dstKey := fmt.Sprintf("%s-%d", objPrefix, dstID)
+
+	_, err := u.Bucket.Object(dstKey).CopierFrom(u.Bucket.Object(srcKey)).Run(ctx)
+	if err != nil {
+		result.Error = fmt.Errorf("error copying object %s to %s: %v", srcKey, dstKey, err)
+	}
+	return
+}
+
 func (u *GCP) DoDelete(ctx context.Context, id int) error {
 	key := fmt.Sprintf("%s-%d", objPrefix, id)
 
@@ -69,13 +79,16 @@ func (u *GCP) DoDelete(ctx context.Context, id int) error {
 	return err
 }
 
-func (u *GCP) DoDownload(ctx context.Context, id int) (result TransferResult) {
-	var err error
-	var copied int64
-
+func (u *GCP) DoDownload(ctx context.Context, id int, rng byteRange) (result TransferResult) {
 	key := fmt.Sprintf("%s-%d", objPrefix, id)
 
-	objReader, err := u.Bucket.Object(key).NewReader(ctx)
+	// A negative length reads until the end of the object
+	offset, length := int64(0), int64(-1)
+	if !rng.whole {
+		offset, length = int64(rng.Offset), int64(rng.Length)
+	}
+
+	objReader, err := u.Bucket.Object(key).NewRangeReader(ctx, offset, length)
 
 	if err != nil {
 		result.Error = fmt.Errorf("error downloading object %s: %v", key, err)
@@ -83,24 +96,44 @@ func (u *GCP) DoDownload(ctx context.Context, id int) (result TransferResult) {
 	}
 
 	// manually receive the file
-	copied, err = io.Copy(ioutil.Discard, objReader)
+	result.Error = receiveObject(id, rng, objReader)
 
-	if err != nil {
-		_ = objReader.Close()
-		result.Error = fmt.Errorf("error receiving response %v", err.Error())
-		return
+	err = objReader.Close()
+
+	if result.Error == nil && err != nil {
+		result.Error = fmt.Errorf("error closing object %v", err.Error())
 	}
 
-	err = objReader.Close()
+	return
+}
 
+func (u *GCP) DoHead(ctx context.Context, id int) (result TransferResult) {
+	key := fmt.Sprintf("%s-%d", objPrefix, id)
+
+	attrs, err := u.Bucket.Object(key).Attrs(ctx)
 	if err != nil {
-		result.Error = fmt.Errorf("error closing object %v", err.Error())
+		result.Error = fmt.Errorf("error reading metadata of object %s: %v", key, err)
 		return
 	}
 
-	if uint64(copied) != object_size {
-		result.Error = fmt.Errorf("wrong response size")
-		return
+	result.Error = checkObjectSize(id, attrs.Size)
+	return
+}
+
+func (u *GCP) DoList(ctx context.Context, prefix string) (result TransferResult) {
+	it := u.Bucket.Objects(ctx, &gstorage.Query{Prefix: prefix})
+	it.PageInfo().MaxSize = listPageSize
+
+	for {
+		_, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			result.Error = fmt.Errorf("error listing objects %s: %v", prefix, err)
+			return
+		}
+		result.Objects++
 	}
 
 	return
@@ -113,12 +146,11 @@ func (u *GCP) DoUpload(ctx context.Context, id int, data io.ReadSeeker) (result
 
 	var multipartPartSize = part_size
 
-	if !u.UseMultipart {
-		objReader := bytes.NewReader(object_data)
-
-		multipartPartSize = object_size
+	// An empty object has no part to compose
+	if !u.UseMultipart || objectSize(id) == 0 {
+		multipartPartSize = objectSize(id)
 		objWriter := u.Bucket.Object(key).NewWriter(ctx)
-		_, err = io.Copy(objWriter, objReader)
+		_, err = io.Copy(objWriter, data)
 
 		if err != nil {
 			_ = objWriter.Close()
@@ -137,27 +169,32 @@ func (u *GCP) DoUpload(ctx context.Context, id int, data io.ReadSeeker) (result
 		return
 	}
 
-	if object_size/multipartPartSize+1 > 32 {
+	size := objectSize(id)
+	if size/multipartPartSize+1 > 32 {
 		log.Fatal("can't split in more than 32 parts")
 	}
 
 	index := 0
 	uploadedObjects := make([]*gstorage.ObjectHandle, 0, 32)
-	for sent := uint64(0); sent < object_size; {
+	for sent := uint64(0); sent < size; {
 		partEnd := sent + multipartPartSize
 
-		if partEnd > object_size {
-			partEnd = object_size
+		if partEnd > size {
+			partEnd = size
 		}
 
-		part := object_data[sent:partEnd]
+		partReader, err := payloadSection(data, int64(sent), int64(partEnd-sent))
+		if err != nil {
+			result.Error = fmt.
+				Errorf("error reading part %d for %s: %v", index, key, err)
+			return
+		}
 
 		partKey := fmt.Sprintf("%s_%d", key, index)
 
 		partObject := u.Bucket.Object(partKey)
 		partWriter := partObject.NewWriter(ctx)
 
-		partReader := bytes.NewReader(part)
 		_, err = io.Copy(partWriter, partReader)
 
 		if err != nil {
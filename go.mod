@@ -21,6 +21,7 @@ module rs-benchmark
 require (
 	cloud.google.com/go v0.37.4
 	code.cloudfoundry.org/bytefmt v0.0.0-20180906201452-2aa6f33b730c
+	github.com/Azure/azure-pipeline-go v0.1.8
 	github.com/Azure/azure-storage-blob-go v0.6.0
 	github.com/aws/aws-sdk-go v1.18.0
 	github.com/golang/protobuf v1.3.1 // indirect
@@ -36,12 +37,12 @@ require (
 	golang.org/x/net v0.0.0-20190419010253-1f3472d942ba // indirect
 	golang.org/x/oauth2 v0.0.0-20190402181905-9f3314589c9a // indirect
 	golang.org/x/sys v0.0.0-20190419153524-e8e3143a4f4a // indirect
-	google.golang.org/api v0.3.2 // indirect
+	google.golang.org/api v0.3.2
 	google.golang.org/appengine v1.5.0 // indirect
 	google.golang.org/genproto v0.0.0-20190418145605-e7d98fc518a7 // indirect
 	google.golang.org/grpc v1.20.1 // indirect
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
-	gopkg.in/yaml.v2 v2.2.2 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
 
 go 1.13
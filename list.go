@@ -0,0 +1,140 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Whether to run the LIST phase, and the number of objects the listed
+// bucket must contain
+var listEnabled bool
+var listObjects int
+
+// Maximum number of objects returned by each listing request
+var listPageSize int
+
+// runListPhase measures listings of all the objects uploaded by the loop,
+// after uploading more objects if needed to reach listObjects
+func runListPhase() phaseRun {
+	populate(listObjects)
+
+	indexes := make(chan int, threads)
+	res := make(chan TransferResult, threads)
+
+	ctx, cancelRemainingListings := context.WithCancel(context.Background())
+	for n := 0; n <= threads; n++ {
+		go runList(ctx, indexes, res)
+	}
+
+	recorder := startIntervalRecorder("LIST", warmup)
+	startTime := time.Now().Add(warmup)
+	listResults := runAndCollectResults(indexes, res, recorder)
+	cancelRemainingListings()
+	checkSchedule("LIST")
+	listTime := time.Now().Sub(startTime).Seconds()
+
+	return phaseRun{
+		Operation: "LIST",
+		Time:      listTime,
+		Results:   measuredResults(listResults, startTime),
+		Intervals: recorder.stop(),
+	}
+}
+
+func runList(ctx context.Context, indexes chan int, res chan TransferResult) {
+	for id := range indexes {
+		startTime := waitTurn(ctx, 0)
+		r := client.DoList(ctx, objPrefix+"-")
+
+		r.Start = startTime
+		r.Duration = time.Now().Sub(startTime)
+		r.Id = id
+
+		logTransferError(r.Error)
+		res <- r
+	}
+}
+
+// nextObjectID returns the id following the ones of the objects of the loop
+func nextObjectID() int {
+	next := 0
+	for _, id := range successFulUploadsIDs {
+		if id >= next {
+			next = id + 1
+		}
+	}
+	return next
+}
+
+// populate uploads new objects until successFulUploadsIDs holds at least n
+// objects. These uploads are not measured.
+func populate(n int) {
+	missing := n - len(successFulUploadsIDs)
+	if missing <= 0 {
+		return
+	}
+
+	nextID := nextObjectID()
+
+	fmt.Printf("Uploading %d more objects\n", missing)
+
+	ctx := context.Background()
+	var mu sync.Mutex
+	var failedUploads int64
+	ids := make(chan int)
+	wg := sync.WaitGroup{}
+	wg.Add(threads)
+	for t := 0; t < threads; t++ {
+		go func() {
+			defer wg.Done()
+
+			for id := range ids {
+				r := client.DoUpload(ctx, id, newPayload(id))
+				if r.Error != nil {
+					logTransferError(r.Error)
+					atomic.AddInt64(&failedUploads, 1)
+					continue
+				}
+
+				mu.Lock()
+				successFulUploadsIDs = append(successFulUploadsIDs, id)
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < missing; i++ {
+		ids <- nextID + i
+		if i > 0 && i%1000 == 0 {
+			fmt.Printf("%d uploads completed\n", i)
+		}
+	}
+	close(ids)
+	wg.Wait()
+
+	if failedUploads > 0 {
+		fmt.Printf("%d uploads failed, listing %d objects\n", failedUploads, len(successFulUploadsIDs))
+	}
+}
@@ -22,24 +22,25 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
-	"math/rand"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	"code.cloudfoundry.org/bytefmt"
 	log "github.com/sirupsen/logrus"
 )
 
+// object_size is the size of the largest object, see objectSize for the
+// size of each object
 var object_size uint64
 var part_size uint64
 var object_data []byte
@@ -50,50 +51,224 @@ var successFulUploadsIDs []int
 var multipartConcurrency int
 var objPrefix string
 var maxRetries int
+var headEnabled bool
 var version string
 
 func main() {
 
 	version = "1.0"
 
+	//If no arguments are passed
+	if len(os.Args) == 1 {
+		fmt.Printf("usage: ./rs-benchmark [OPTIONS]\n\n")
+		fmt.Println("For help, run ./rs-benchmark -h.")
+		os.Exit(-1)
+	}
+
+	// Subcommands
+	switch os.Args[1] {
+	case "serve-s3":
+		serveS3(os.Args[2:])
+		return
+	case "worker":
+		runWorker(os.Args[2:])
+		return
+	case "compare":
+		runCompare(os.Args[2:])
+		return
+	}
+
+	args := os.Args[1:]
+	options := configure(args)
+	if len(options.targets) > 1 {
+		runTargets(args, options.targets)
+	} else {
+		runBenchmark(args, options)
+	}
+
+	fmt.Println("\nDone.")
+}
+
+// runBenchmark runs the loops at every sweep point with the options given by
+// args, and returns the report of the run
+func runBenchmark(args []string, options benchmarkOptions) BenchmarkReport {
+	outputPath, outputFormat := options.outputPath, options.outputFormat
+	pauseBetweenPhases := options.pauseBetweenPhases
+
+	report := BenchmarkReport{
+		Version:    version,
+		Date:       time.Now(),
+		Parameters: options.parameters,
+	}
+
+	runPhase = runLocalPhase
+	var coord *coordinator
+	if len(options.workers) > 0 {
+		// The workers get the same options, with the seed of this run, and
+		// serve their own metrics if any
+		workerArgs := append(append([]string{}, args...), "-workers=", "-worker-token=", "-metrics-listen=", fmt.Sprintf("-seed=%d", payloadSeed))
+
+		var err error
+		if coord, err = newCoordinator(options.workers, options.workerToken, workerArgs, options.workload); err != nil {
+			log.Fatal(err)
+		}
+		defer coord.close()
+		runPhase = coord.runPhase
+	}
+
+	points := sweepPoints()
+
+	// Loop running the tests
+	loop := 0
+	for i, point := range points {
+		if coord != nil {
+			coord.point = i
+		}
+		if point.apply != nil {
+			point.apply()
+			fmt.Printf("\nRunning with %s\n", point.name)
+		}
+
+		var pointLoops []LoopResult
+		for i := 1; i <= loops; i++ {
+			loop++
+			result := runLoop(loop, pauseBetweenPhases)
+			report.Loops = append(report.Loops, result)
+			pointLoops = append(pointLoops, result)
+
+			if outputPath != "" {
+				if err := writeReport(outputPath, outputFormat, &report); err != nil {
+					log.Errorf("unable to write results to %s: %v", outputPath, err)
+				}
+			}
+		}
+
+		if len(points) > 1 {
+			report.Sweep = append(report.Sweep, newSweepResults(pointLoops)...)
+		}
+	}
+
+	if len(points) > 1 {
+		if threadSweep != nil {
+			markSaturation(report.Sweep)
+		}
+		printSweepResults(report.Sweep)
+
+		if outputPath != "" {
+			if err := writeReport(outputPath, outputFormat, &report); err != nil {
+				log.Errorf("unable to write results to %s: %v", outputPath, err)
+			}
+		}
+	}
+
+	return report
+}
+
+// benchmarkOptions are the options of a run which are not held in package
+// globals
+type benchmarkOptions struct {
+	outputPath, outputFormat string
+	pauseBetweenPhases       bool
+	workers                  []string
+	workerToken              string
+	// Content of the -config workload file, sent to the workers
+	workload   []byte
+	parameters BenchmarkParameters
+	// Set when running against several targets, which are configured
+	// in turn
+	targets []string
+}
+
+// configure parses the benchmark options, sets up the client and prints the
+// parameters. Invalid options exit the process.
+func configure(args []string) benchmarkOptions {
+	options, err := parseOptions(args, flag.ExitOnError, ioutil.ReadFile)
+	if err != nil {
+		fmt.Println(err)
+		printHelp()
+	}
+	return options
+}
+
+// parseOptions is configure returning the errors of invalid options, for the
+// workers which must survive them. Flag errors are handled as errorHandling
+// says, and -h and -version are only accepted with flag.ExitOnError. The
+// -config workload file is read with readFile.
+func parseOptions(args []string, errorHandling flag.ErrorHandling, readFile func(string) ([]byte, error)) (benchmarkOptions, error) {
 	var access_key, secret_key, url_host, bucket, region, sizeArg, multipartSizeArg string
 	var protocol string
 	var useMultipart, help, showVersion bool
 	var pauseBetweenPhases bool
 	var hostIP string
+	var outputPath, outputFormat string
+	var mixArg string
+	var rangeSizeArg string
+	var bandwidthArg string
+	var threadSweepArg, sizeSweepArg string
+	var workersArg, workerToken string
+	var metricsAddr string
+	var workloadPath, targetsArg string
+	var fsync, directIO bool
+	var memLatency time.Duration
+	var memBandwidthArg string
+	var memErrorRate float64
 
 	// Parse command line
-	myflag := flag.NewFlagSet("rs-benchmark", flag.ExitOnError)
+	myflag := flag.NewFlagSet("rs-benchmark", errorHandling)
 	myflag.StringVar(&access_key, "a", "", "Access key")
 	myflag.StringVar(&secret_key, "s", "", "Secret key")
-	myflag.StringVar(&url_host, "u", "", "URL for endpoint with method prefix (e.g. https://s3.YOUR_CUSTOMER_NAME.rstorlabs.io)")
+	myflag.StringVar(&url_host, "u", "", "URL for endpoint with method prefix (e.g. https://s3.YOUR_CUSTOMER_NAME.rstorlabs.io), or directory for protocol file")
 	myflag.StringVar(&bucket, "b", "", "Bucket for testing")
 	myflag.BoolVar(&help, "h", false, "Show help screen")
 	myflag.IntVar(&duration_secs, "d", 60, "Duration of each test in seconds")
 	myflag.IntVar(&threads, "t", 1, "Number of parallel requests to run")
+	myflag.StringVar(&sizeSweepArg, "z-sweep", "", "run the loops with each of these object sizes instead of -z, a list (4K,1M,64M) or a range of doubling sizes (4K-1G)")
+	myflag.StringVar(&threadSweepArg, "t-sweep", "", "run the loops with each of these thread counts instead of -t, a list (1,8,32) or a range of powers of two (1-256)")
 	myflag.IntVar(&loops, "l", 1, "Number of times to repeat test")
 	myflag.BoolVar(&verbose, "v", false, "Verbose error output")
 	myflag.BoolVar(&showVersion, "version", false, "Show version")
 	myflag.StringVar(&region, "r", "", "Region for testing")
-	myflag.StringVar(&protocol, "protocol", "", "client protocol: s3v2, s3v4, azure, gcp")
+	myflag.StringVar(&protocol, "protocol", "", "client protocol: s3v2, s3v4, azure, gcp, file, mem")
 	myflag.BoolVar(&useMultipart, "multipart", false, "use multipart")
 	myflag.IntVar(&multipartConcurrency, "multipart-concurrency", 5, "concurrency to use for multipart requests")
 	myflag.BoolVar(&pauseBetweenPhases, "pause", false, "whether to pause between upload and download tests")
 	myflag.StringVar(&hostIP, "ip", "", "forces all hostnames to resolve to this address (s3v2, s3v4 only)")
-	myflag.StringVar(&objPrefix, "prefix", "Object", "will create objects with key: 'prefix-number'")
+	myflag.StringVar(&objPrefix, "prefix", "Object", "will create objects with key: 'prefix-number', without path separators or \"..\"")
 	myflag.IntVar(&maxRetries, "maxRetries", 0, "number of retries on failure (default 0. s3v4 only)")
-	myflag.StringVar(&sizeArg, "z", "1M", "Size of objects in bytes with suffix K, M, and G")
+	myflag.StringVar(&sizeArg, "z", "1M", "Size of objects in bytes with suffix K, M, and G, or a distribution of sizes")
 	myflag.StringVar(&multipartSizeArg, "multipart-size", "5M", "Size of the multipart chunks")
-
-	//If no arguments are passed
-	if len(os.Args) == 1 {
-		fmt.Printf("usage: ./rs-benchmark [OPTIONS]\n\n")
-		fmt.Println("For help, run ./rs-benchmark -h.")
-		os.Exit(-1)
-	}
+	myflag.BoolVar(&fsync, "fsync", false, "fsync every object after writing it (file only)")
+	myflag.BoolVar(&directIO, "direct", false, "read objects with O_DIRECT, bypassing the page cache (file only)")
+	myflag.DurationVar(&memLatency, "mem-latency", 0, "latency added to every request (mem only)")
+	myflag.StringVar(&memBandwidthArg, "mem-bandwidth", "0", "aggregate bandwidth cap per second with suffix K, M, and G, 0 for none (mem only)")
+	myflag.Float64Var(&memErrorRate, "mem-error-rate", 0, "fraction of requests failing, between 0 and 1 (mem only)")
+	myflag.StringVar(&payloadMode, "payload", payloadRandom, "content of the objects: random (the same random data for every object), unique (different random data for every object), zeros")
+	myflag.Int64Var(&payloadSeed, "seed", 0, "seed of the random content of the objects (default: a new seed for every run)")
+	myflag.Float64Var(&compressionRatio, "compress-ratio", 1, "target compression ratio of the random and unique payloads, e.g. 2.0, 1 for incompressible")
+	myflag.BoolVar(&verifyDownloads, "verify", false, "verify the content of the downloaded objects")
+	myflag.StringVar(&rangeSizeArg, "range-size", "0", "read ranges of this size with suffix K, M, and G in the GET and mixed phases, 0 to read whole objects")
+	myflag.StringVar(&rangePattern, "range-pattern", rangeSequential, "offsets of the ranges read: sequential, random")
+	myflag.BoolVar(&headEnabled, "head", false, "run a HEAD phase, reading the metadata of the uploaded objects")
+	myflag.BoolVar(&listEnabled, "list", false, "run a LIST phase, listing all the objects of the loop")
+	myflag.IntVar(&listObjects, "list-objects", 0, "upload objects before the LIST phase until the bucket holds this many")
+	myflag.IntVar(&listPageSize, "list-page-size", 1000, "maximum number of objects returned by each listing request")
+	myflag.BoolVar(&copyEnabled, "copy", false, "run a COPY phase, copying the uploaded objects to new keys on the server side")
+	myflag.IntVar(&deleteBatchSize, "delete-batch", 1, "number of objects deleted by each request of the DELETE phase (s3v2, s3v4, azure, mem only)")
+	myflag.StringVar(&mixArg, "mix", "", "run a mixed workload instead of the GET phase, with the given weights, e.g. get=70,put=25,delete=5")
+	myflag.Float64Var(&targetRate, "rate", 0, "send this many requests per second whatever their latency, instead of running in closed loop")
+	myflag.StringVar(&bandwidthArg, "bandwidth", "0", "send requests at this many bytes per second with suffix K, M, and G, instead of running in closed loop")
+	myflag.DurationVar(&warmup, "warmup", 0, "run the time-limited phases for this long before measuring them, e.g. 10s")
+	myflag.DurationVar(&reportInterval, "interval", 0, "print the statistics of every phase for each interval of this length, e.g. 1s")
+	myflag.StringVar(&workloadPath, "config", "", "read the options and the phases of the loops from this YAML or JSON workload file, the options given on the command line take precedence")
+	myflag.StringVar(&targetsArg, "targets", "", "run the benchmark against these targets of the -config workload file in turn and compare them, e.g. rstor,aws (default: all of them)")
+	myflag.StringVar(&workersArg, "workers", "", "run the benchmark on these workers, e.g. host1:7070,host2:7070, each of them running -t threads")
+	myflag.StringVar(&workerToken, "worker-token", os.Getenv(workerTokenEnv), "token shared with the -workers (default: $"+workerTokenEnv+")")
+	myflag.StringVar(&metricsAddr, "metrics-listen", "", "serve Prometheus metrics on this address while running, e.g. :9100")
+	myflag.StringVar(&outputPath, "output", "", "write the results of every loop to this file")
+	myflag.StringVar(&outputFormat, "output-format", "", "format of the -output file: json, csv (default: guessed from the file extension)")
 
 	//for --help flag - need to find a more elegant solution
-	if os.Args[1] == "--help" {
+	if errorHandling == flag.ExitOnError && len(args) > 0 && args[0] == "--help" {
 		fmt.Println("Available arguments:")
 		myflag.PrintDefaults()
 		fmt.Println("")
@@ -101,13 +276,35 @@ func main() {
 	}
 
 	// Parse arguments
-	if err := myflag.Parse(os.Args[1:]); err != nil {
-		fmt.Println("Unable to parse flags")
-		printHelp()
+	if err := myflag.Parse(args); err != nil {
+		return benchmarkOptions{}, fmt.Errorf("Unable to parse flags: %v", err)
+	}
+
+	var workload []workloadPhase
+	var workloadData []byte
+	var targets []string
+	if workloadPath != "" {
+		var err error
+		if workloadData, err = readFile(workloadPath); err != nil {
+			return benchmarkOptions{}, fmt.Errorf("Invalid -config workload file: %v", err)
+		}
+		if workload, targets, err = loadWorkload(myflag, workloadPath, workloadData); err != nil {
+			return benchmarkOptions{}, fmt.Errorf("Invalid -config workload file: %v", err)
+		}
+	} else if targetsArg != "" {
+		return benchmarkOptions{}, errors.New("-targets requires a -config workload file listing the targets.")
+	}
+	workloadSteps = nil
+	if len(workload) > 0 {
+		workloadSteps = workloadPhaseSteps(workload)
 	}
 
 	// Check the arguments
 
+	if (showVersion || help) && errorHandling != flag.ExitOnError {
+		return benchmarkOptions{}, errors.New("-h and -version don't run a benchmark")
+	}
+
 	if showVersion == true {
 		fmt.Printf("RStor rs-benchmark v%s.\n\n", version)
 		os.Exit(0)
@@ -115,7 +312,7 @@ func main() {
 
 	fmt.Printf("rs-benchmark v%s - a compact tool for benchmarking different object storages\n", version)
 	fmt.Println("Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)")
-	fmt.Println("Released under GPL v3 license\n")
+	fmt.Print("Released under GPL v3 license\n\n")
 
 	if help == true {
 		fmt.Println("Available arguments:")
@@ -124,25 +321,38 @@ func main() {
 		os.Exit(0)
 	}
 
-	if protocol == "" {
-		fmt.Println("Missing argument -protocol for client protocol.")
-		printHelp()
+	if metricsAddr != "" {
+		if err := startMetricsServer(metricsAddr); err != nil {
+			return benchmarkOptions{}, err
+		}
+	}
+
+	if len(targets) > 1 {
+		// Each target is configured in turn when running against it
+		return benchmarkOptions{targets: targets}, nil
+	}
 
+	if protocol == "" {
+		return benchmarkOptions{}, errors.New("Missing argument -protocol for client protocol.")
 	}
 
 	if protocol == "s3v4" && region == "" {
-		fmt.Println("Protocol s3v4 requires the region to be specified.")
-		printHelp()
-		os.Exit(-1)
+		return benchmarkOptions{}, errors.New("Protocol s3v4 requires the region to be specified.")
 	}
 
 	hostIPForPrinting := ""
-	if hostIP == "" && url_host == "" {
-		fmt.Println("Missing host information.")
-		printHelp()
+	if hostIP == "" && url_host == "" && protocol != "mem" {
+		return benchmarkOptions{}, errors.New("Missing host information.")
 	}
 
-	if hostIP != "" {
+	if protocol == "mem" {
+		hostIPForPrinting = "in-process"
+	} else if protocol == "file" {
+		if url_host == "" {
+			return benchmarkOptions{}, errors.New("Protocol file requires the directory to be specified with -u.")
+		}
+		hostIPForPrinting = "local"
+	} else if hostIP != "" {
 		dTransport := httpClient.Transport.(*http.Transport)
 
 		dTransport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -154,15 +364,13 @@ func main() {
 	} else {
 		u, err := url.Parse(url_host)
 		if err != nil {
-			fmt.Println("Invalid url ", err)
-			printHelp()
+			return benchmarkOptions{}, fmt.Errorf("Invalid url %v", err)
 		}
 
 		host := strings.Split(u.Host, ":")[0]
 		ips, err := net.LookupIP(host)
 		if err != nil {
-			fmt.Println("Can't resolve host ", u.Host)
-			printHelp()
+			return benchmarkOptions{}, fmt.Errorf("Can't resolve host %s", u.Host)
 		} else {
 			var ipStrings []string
 			for _, ip := range ips {
@@ -172,32 +380,107 @@ func main() {
 		}
 	}
 
-	if protocol != "gcp" {
+	if protocol != "gcp" && protocol != "file" && protocol != "mem" {
 		if access_key == "" {
-			fmt.Println("Missing argument -a for access key.")
-			printHelp()
+			return benchmarkOptions{}, errors.New("Missing argument -a for access key.")
 		}
 		if secret_key == "" {
-			fmt.Println("Missing argument -s for secret key.")
-			printHelp()
+			return benchmarkOptions{}, errors.New("Missing argument -s for secret key.")
 		}
 	}
 
 	if bucket == "" {
-		fmt.Println("Missing argument -b for bucket.")
-		printHelp()
+		return benchmarkOptions{}, errors.New("Missing argument -b for bucket.")
+	}
+
+	// The keys of the objects must not escape the bucket or the directory
+	if strings.ContainsAny(objPrefix, `/\`) || strings.Contains(objPrefix, "..") {
+		return benchmarkOptions{}, errors.New("-prefix must not contain path separators or \"..\"")
 	}
 
 	var err error
 
-	if object_size, err = bytefmt.ToBytes(sizeArg); err != nil {
-		fmt.Println("Invalid -z argument for object size: %v", err)
-		printHelp()
+	sizes, err := parseSizeDistribution(sizeArg)
+	if err != nil {
+		return benchmarkOptions{}, fmt.Errorf("Invalid -z argument for object size: %v", err)
+	}
+	setBaseObjectSizes(sizes)
+
+	if sizeSweepArg != "" {
+		if sizeSweep, err = parseSizeSweep(sizeSweepArg); err != nil {
+			return benchmarkOptions{}, fmt.Errorf("Invalid -z-sweep argument: %v", err)
+		}
+		// The payload must hold the largest object of the sweep
+		for _, size := range sizeSweep {
+			if size > object_size {
+				object_size = size
+			}
+		}
+	}
+	for _, p := range workload {
+		if p.sizes != nil && p.sizes.maxSize() > object_size {
+			object_size = p.sizes.maxSize()
+		}
 	}
 
 	if part_size, err = bytefmt.ToBytes(multipartSizeArg); err != nil {
-		fmt.Println("Invalid -multipart-size argument for part size: %v", err)
-		printHelp()
+		return benchmarkOptions{}, fmt.Errorf("Invalid -multipart-size argument for part size: %v", err)
+	}
+
+	if rangeSizeArg != "0" {
+		if rangeSize, err = bytefmt.ToBytes(rangeSizeArg); err != nil {
+			return benchmarkOptions{}, fmt.Errorf("Invalid -range-size argument: %v", err)
+		}
+	}
+
+	if threadSweepArg != "" {
+		if threadSweep, err = parseThreadSweep(threadSweepArg); err != nil {
+			return benchmarkOptions{}, fmt.Errorf("Invalid -t-sweep argument: %v", err)
+		}
+	}
+
+	if bandwidthArg != "0" {
+		if targetBandwidth, err = bytefmt.ToBytes(bandwidthArg); err != nil {
+			return benchmarkOptions{}, fmt.Errorf("Invalid -bandwidth argument: %v", err)
+		}
+	}
+
+	if targetRate < 0 {
+		return benchmarkOptions{}, errors.New("Invalid -rate argument: must be positive")
+	}
+
+	if rangePattern != rangeSequential && rangePattern != rangeRandom {
+		return benchmarkOptions{}, errors.New("Invalid -range-pattern argument: available: sequential, random")
+	}
+
+	switch payloadMode {
+	case payloadRandom, payloadUnique, payloadZeros:
+	default:
+		return benchmarkOptions{}, errors.New("Invalid -payload argument: available: random, unique, zeros")
+	}
+
+	if mixArg != "" {
+		if mixRatios, err = parseMix(mixArg); err != nil {
+			return benchmarkOptions{}, fmt.Errorf("Invalid -mix argument: %v", err)
+		}
+	}
+
+	if listPageSize < 1 {
+		return benchmarkOptions{}, errors.New("-list-page-size must be at least 1")
+	}
+
+	if compressionRatio < 1 {
+		return benchmarkOptions{}, errors.New("-compress-ratio must be at least 1")
+	}
+
+	if payloadSeed == 0 {
+		payloadSeed = time.Now().UnixNano()
+	}
+
+	if outputPath != "" {
+		if outputFormat, err = reportFormat(outputPath, outputFormat); err != nil {
+			return benchmarkOptions{}, err
+		}
 	}
 
 	switch protocol {
@@ -207,12 +490,10 @@ func main() {
 		client = v4Client
 	case "s3v2":
 		if useMultipart {
-			fmt.Println("Multipart not supported")
-			printHelp()
+			return benchmarkOptions{}, errors.New("Multipart not supported")
 		}
 		if region != "" {
-			fmt.Println("-region not supported for s3v2. Drop option.")
-			printHelp()
+			return benchmarkOptions{}, errors.New("-region not supported for s3v2. Drop option.")
 		}
 		client = NewS3AwsV2(access_key, secret_key, url_host, region)
 	case "azure":
@@ -223,6 +504,9 @@ func main() {
 			fmt.Println("Multipart concurrency is fixed to one")
 			multipartConcurrency = 1
 		}
+		if _, err := base64.StdEncoding.DecodeString(secret_key); err != nil {
+			return benchmarkOptions{}, fmt.Errorf("Invalid -s secret key, expected base64: %v", err)
+		}
 		aup := NewAzureUploader(access_key, secret_key, url_host, region)
 		aup.UseMultipart = useMultipart
 		client = aup
@@ -237,12 +521,54 @@ func main() {
 		gup := NewGCP(access_key, secret_key, url_host, region)
 		gup.UseMultipart = useMultipart
 		client = gup
+	case "file":
+		if useMultipart {
+			return benchmarkOptions{}, errors.New("Multipart not supported")
+		}
+		if directIO && !directIOSupported {
+			fmt.Println("-direct is not supported on this platform, reads go through the page cache")
+		}
+		fup := NewFileUploader(url_host)
+		fup.Fsync = fsync
+		fup.DirectIO = directIO && directIOSupported
+		client = fup
+	case "mem":
+		if useMultipart {
+			return benchmarkOptions{}, errors.New("Multipart not supported")
+		}
+		memBandwidth, err := bytefmt.ToBytes(memBandwidthArg)
+		if err != nil && memBandwidthArg != "0" {
+			return benchmarkOptions{}, fmt.Errorf("Invalid -mem-bandwidth argument: %v", err)
+		}
+		if memErrorRate < 0 || memErrorRate > 1 {
+			return benchmarkOptions{}, errors.New("-mem-error-rate must be between 0 and 1")
+		}
+		client = NewMemUploader(memLatency, memBandwidth, memErrorRate)
 	default:
-		fmt.Println("unknown client type: available: s3v4, s3v2, azure, gpc")
-		printHelp()
+		return benchmarkOptions{}, errors.New("unknown client type: available: s3v4, s3v2, azure, gcp, file, mem")
+	}
+	if deleteBatchSize < 1 || deleteBatchSize > maxDeleteBatchSize {
+		return benchmarkOptions{}, fmt.Errorf("-delete-batch must be between 1 and %d", maxDeleteBatchSize)
 	}
+	if protocol == "azure" && deleteBatchSize > azureMaxBatchSize {
+		return benchmarkOptions{}, fmt.Errorf("-delete-batch must be at most %d with protocol azure", azureMaxBatchSize)
+	}
+	if _, ok := client.(BatchDeleter); deleteBatchSize > 1 && !ok {
+		// Rather than silently deleting the objects one at a time
+		return benchmarkOptions{}, fmt.Errorf("-delete-batch is not supported by protocol %s, which has no batch delete API: use -delete-batch 1", protocol)
+	}
+
 	fmt.Println("Benchmark parameters:")
 
+	var target string
+	if len(targets) == 1 {
+		target = targets[0]
+		fmt.Printf("%-15s%s\n", "Target", target)
+	}
+	metricsBackend = protocol
+	if target != "" {
+		metricsBackend = target
+	}
 	fmt.Printf("%-15s%s\n", "Endpoint URL", url_host)
 	fmt.Printf("%-15s%s\n", "Protocol", protocol)
 	fmt.Printf("%-15s%s\n", "Host ip", hostIPForPrinting)
@@ -251,171 +577,390 @@ func main() {
 		fmt.Printf("%-15s%s\n", "Region", region)
 	}
 	fmt.Printf("%-15s%d\n", "Test time", duration_secs)
-	fmt.Printf("%-15s%d\n", "Threads", threads)
-	fmt.Printf("%-15s%s\n", "Size", sizeArg)
+	if warmup > 0 {
+		fmt.Printf("%-15s%v\n", "Warmup", warmup)
+	}
+	if reportInterval > 0 {
+		fmt.Printf("%-15s%v\n", "Interval", reportInterval)
+	}
+	if targetRate > 0 {
+		fmt.Printf("%-15s%g requests/s\n", "Rate", targetRate)
+	}
+	if targetBandwidth > 0 {
+		fmt.Printf("%-15s%s/s\n", "Bandwidth", bytefmt.ByteSize(targetBandwidth))
+	}
+	if threadSweep != nil {
+		fmt.Printf("%-15s%s\n", "Threads", threadSweepArg)
+	} else {
+		fmt.Printf("%-15s%d\n", "Threads", threads)
+	}
+	if sizeSweep != nil {
+		fmt.Printf("%-15s%s\n", "Size", sizeSweepArg)
+	} else {
+		fmt.Printf("%-15s%s\n", "Size", objectSizes)
+	}
 	fmt.Printf("%-15s%d\n", "Loops", loops)
+	if workloadPath != "" {
+		fmt.Printf("%-15s%s (%d phases)\n", "Workload", workloadPath, len(workloadSteps))
+	}
+	if mixArg != "" {
+		fmt.Printf("%-15s%s\n", "Mix", mixArg)
+	}
+	if rangeSize > 0 {
+		fmt.Printf("%-15s%s, %s\n", "Ranges", rangeSizeArg, rangePattern)
+	}
+	if headEnabled {
+		fmt.Printf("%-15s%t\n", "Head", headEnabled)
+	}
+	if copyEnabled {
+		fmt.Printf("%-15s%t\n", "Copy", copyEnabled)
+	}
+	if listEnabled {
+		fmt.Printf("%-15s%d per page", "List", listPageSize)
+		if listObjects > 0 {
+			fmt.Printf(", at least %d objects", listObjects)
+		}
+		fmt.Println("")
+	}
 	fmt.Printf("%-15s%t", "Multipart", useMultipart)
 	if useMultipart == true {
 		fmt.Printf(", %s per part, %d parallel uploads", multipartSizeArg, multipartConcurrency)
 	}
 	fmt.Println("")
+	if deleteBatchSize > 1 {
+		fmt.Printf("%-15s%d objects per request\n", "Delete batch", deleteBatchSize)
+	}
 	fmt.Printf("%-15s%d\n", "Max retries", maxRetries)
+	fmt.Printf("%-15s%s", "Payload", payloadMode)
+	if payloadMode != payloadZeros {
+		fmt.Printf(", seed %d", payloadSeed)
+		if compressionRatio > 1 {
+			fmt.Printf(", compression ratio %.2f", compressionRatio)
+		}
+	}
+	if verifyDownloads {
+		fmt.Print(", verified")
+	}
+	fmt.Println("")
+	if outputPath != "" {
+		fmt.Printf("%-15s%s (%s)\n", "Output", outputPath, outputFormat)
+	}
 
-	// Test access to the bucket
-	err = client.Prepare(bucket)
-	if err != nil {
-		log.Fatal(err)
-		fmt.Println("For more information, run again with flag -v.")
+	var workers []string
+	if workersArg != "" {
+		if workerToken == "" {
+			return benchmarkOptions{}, fmt.Errorf("-workers requires the token of the workers, given with -worker-token or $%s", workerTokenEnv)
+		}
+		workers = strings.Split(workersArg, ",")
+		fmt.Printf("%-15s%s\n", "Workers", workersArg)
+	} else {
+		// Test access to the bucket, the workers test it themselves
+		err = client.Prepare(bucket)
+		if err != nil {
+			return benchmarkOptions{}, fmt.Errorf("%v\nFor more information, run again with flag -v.", err)
+		}
+
+		// Initialize data for the bucket
+		initPayload()
+	}
+
+	options := benchmarkOptions{
+		outputPath:         outputPath,
+		outputFormat:       outputFormat,
+		pauseBetweenPhases: pauseBetweenPhases,
+		workers:            workers,
+		workerToken:        workerToken,
+		workload:           workloadData,
+		parameters: BenchmarkParameters{
+			Target:      target,
+			Endpoint:    url_host,
+			Protocol:    protocol,
+			HostIP:      hostIPForPrinting,
+			Bucket:      bucket,
+			Region:      region,
+			Duration:    duration_secs,
+			Threads:     threads,
+			ObjectSize:  object_size,
+			Sizes:       objectSizes.String(),
+			Loops:       loops,
+			Multipart:   useMultipart,
+			MaxRetries:  maxRetries,
+			Prefix:      objPrefix,
+			Payload:     payloadMode,
+			Seed:        payloadSeed,
+			Compression: compressionRatio,
+			Mix:         mixArg,
+			Verify:      verifyDownloads,
+			Head:        headEnabled,
+			Copy:        copyEnabled,
+			DeleteBatch: deleteBatchSize,
+			Interval:    reportInterval.Seconds(),
+			Warmup:      warmup.Seconds(),
+			Rate:        targetRate,
+			Bandwidth:   targetBandwidth,
+			ThreadSweep: threadSweepArg,
+			SizeSweep:   sizeSweepArg,
+			Workers:     workers,
+			Workload:    workload,
+		},
+	}
+	if rangeSize > 0 {
+		options.parameters.RangeSize = rangeSize
+		options.parameters.RangePattern = rangePattern
+	}
+	if listEnabled {
+		options.parameters.ListObjects = listObjects
+		options.parameters.ListPageSize = listPageSize
+	}
+	if useMultipart {
+		options.parameters.PartSize = part_size
+		options.parameters.MultipartConcurrency = multipartConcurrency
 	}
 
-	// Initialize data for the bucket
-	object_data = make([]byte, object_size)
-	rand.Read(object_data)
-	// hasher := md5.New()
-	// hasher.Write(object_data)
+	return options, nil
+}
 
-	// Loop running the tests
-	for loop := 1; loop <= loops; loop++ {
-		runLoop(loop, pauseBetweenPhases)
+// phaseStep is a phase of the loops
+type phaseStep struct {
+	operation string
+	// Settings of the phase given by the workload file, nil to use the
+	// options of the run
+	workload *workloadPhase
+}
+
+// loopPhases returns the phases run by every loop, in order
+func loopPhases() []phaseStep {
+	if workloadSteps != nil {
+		return workloadSteps
 	}
 
-	fmt.Println("\nDone.")
+	phases := []phaseStep{{operation: "PUT"}}
+	if mixRatios != nil {
+		phases = append(phases, phaseStep{operation: "MIX"})
+	} else {
+		phases = append(phases, phaseStep{operation: "GET"})
+	}
+	if headEnabled {
+		phases = append(phases, phaseStep{operation: "HEAD"})
+	}
+	if listEnabled {
+		phases = append(phases, phaseStep{operation: "LIST"})
+	}
+	if copyEnabled {
+		// After the phases reading objects, as the copies are not valid
+		// objects for them
+		phases = append(phases, phaseStep{operation: "COPY"})
+	}
+	// The DELETE phase also cleans up the objects of the loop
+	return append(phases, phaseStep{operation: "DELETE"})
+}
+
+// runPhase runs the step-th phase of the loop, in this process unless the
+// run is distributed to workers
+var runPhase = runLocalPhase
+
+func runLocalPhase(step int) []phaseRun {
+	phases := loopPhases()
+	if step < 0 || step >= len(phases) {
+		log.Fatalf("unknown phase %d", step)
+	}
+
+	if step == 0 {
+		// A new loop
+		successFulUploadsIDs = make([]int, 0, 1000)
+		setBaseObjectSizes(baseObjectSizes)
+	}
+
+	phase := phases[step]
+	if phase.workload != nil {
+		restore := phase.workload.apply()
+		defer restore()
+	}
+
+	var runs []phaseRun
+	switch phase.operation {
+	case "PUT":
+		runs = []phaseRun{runUploadPhase()}
+	case "GET":
+		runs = []phaseRun{runDownloadPhase()}
+	case "MIX":
+		runs = runMixed()
+	case "HEAD":
+		runs = []phaseRun{runHeadPhase()}
+	case "LIST":
+		runs = []phaseRun{runListPhase()}
+	case "COPY":
+		runs = []phaseRun{runCopyPhase()}
+	case "DELETE":
+		runs = []phaseRun{runDeletePhase()}
+	default:
+		log.Fatalf("unknown phase %s", phase.operation)
+	}
+
+	for i := range runs {
+		runs[i].Threads = threads
+		runs[i].ObjectSize = phaseObjectSize()
+	}
+	return runs
 }
 
-func runLoop(loop int, pauseBetweenPhases bool) {
+func runLoop(loop int, pauseBetweenPhases bool) LoopResult {
 	if loop > 1 && pauseBetweenPhases {
-		fmt.Println("Loop %d done", loop-1)
+		fmt.Printf("Loop %d done\n", loop-1)
 		pause()
 	}
 
+	result := LoopResult{Loop: loop}
+
 	fmt.Printf("\nStarting loop %d...\n", loop)
-	// Run the upload case
+
+	for step, phase := range loopPhases() {
+		if phase.operation == "DELETE" && pauseBetweenPhases {
+			pause()
+		}
+
+		var successful int
+		for _, run := range runPhase(step) {
+			phase := run.phaseResult()
+			successful += phase.Successful
+			result.Phases = append(result.Phases, phase)
+
+			if len(result.Phases) == 1 {
+				printPhaseHeader()
+			}
+			printPhaseResult(phase)
+		}
+
+		switch phase.operation {
+		case "PUT":
+			if successful < 5 {
+				log.Fatal("Not enough successful uploads to continue.")
+				if verbose == false {
+					fmt.Println("For more information, run again with flag -v.")
+				}
+			}
+			if pauseBetweenPhases {
+				pause()
+			}
+		case "GET":
+			if successful == 0 {
+				log.Fatal("All downloads failed")
+				fmt.Println("For more information, run again with flag -v.")
+			}
+		}
+	}
+
+	fmt.Println("")
+	printLatencyHeader()
+	for _, phase := range result.Phases {
+		printLatencyStats(phase.Operation, phase.Latency)
+	}
+	fmt.Println("")
+	for _, phase := range result.Phases {
+		printHistogram(phase.Operation, phase.Histogram)
+	}
+	for _, phase := range result.Phases {
+		printSizeBuckets(phase)
+	}
+
+	return result
+}
+
+// runUploadPhase uploads new objects, the ones the following phases of the
+// loop work on
+func runUploadPhase() phaseRun {
+	// The new objects get the ids following the ones of the objects
+	// uploaded by the previous PUT phases of the loop, if any
+	firstID := nextObjectID()
 
 	indexes := make(chan int, threads)
 	res := make(chan TransferResult, threads)
-	successFulUploadsIDs = make([]int, 0, 1000)
 
 	ctx, cancelRemainingUploads := context.WithCancel(context.Background())
 	for n := 0; n <= threads; n++ {
-		go runUpload(ctx, indexes, res)
+		go runUpload(ctx, firstID, indexes, res)
 	}
 
-	startTime := time.Now()
-	uploads := runAndCollectResults(indexes, res)
+	recorder := startIntervalRecorder("PUT", warmup)
+	startTime := time.Now().Add(warmup)
+	uploadResults := runAndCollectResults(indexes, res, recorder)
 	cancelRemainingUploads()
+	checkSchedule("PUT")
 	uploadTime := time.Now().Sub(startTime).Seconds()
 
-	var uploadedBytes uint64
-	var uploadDurations []float64
-	var totalDuration float64
-	for _, v := range uploads {
-		if v.Error != nil {
-			continue
-		}
-		successFulUploadsIDs = append(successFulUploadsIDs, v.Id)
-		uploadedBytes += object_size
-		uploadDurations = append(uploadDurations, v.Duration.Seconds())
-		totalDuration += v.Duration.Seconds()
-	}
-	sort.Float64s(uploadDurations)
-
-	// log.Info(successFulUploadsIDs)
-	successfulUploads := len(successFulUploadsIDs)
-	failedUploads := len(uploads) - len(successFulUploadsIDs)
-	uploadMBps := (float64(uploadedBytes) / uploadTime) / (1000 * 1000)
-
-	fmt.Printf("%-9s%-6s%-11s%-7s%-12s%-8s%-6s\n", "Threads", "Size", "Operation", "Time", "Successful", "Failed", "MBps")
-	fmt.Printf("%-9d%-6v%-11s%-7.2f%-12v%-8v%-6.2f\n",
-		threads, bytefmt.ByteSize(object_size), "PUT", uploadTime, successfulUploads, failedUploads, uploadMBps)
-
-	if len(successFulUploadsIDs) < 5 {
-		log.Fatal("Not enough successful uploads to continue.")
-		if verbose == false {
-			fmt.Println("For more information, run again with flag -v.")
+	for _, v := range uploadResults {
+		if v.Error == nil {
+			successFulUploadsIDs = append(successFulUploadsIDs, firstID+v.Id)
 		}
 	}
 
-	if pauseBetweenPhases {
-		pause()
+	return phaseRun{
+		Operation: "PUT",
+		Time:      uploadTime,
+		Results:   measuredResults(uploadResults, startTime),
+		Intervals: recorder.stop(),
 	}
+}
 
-	// Run the download case
-	indexes = make(chan int, threads)
-	res = make(chan TransferResult, 10)
+func runDownloadPhase() phaseRun {
+	indexes := make(chan int, threads)
+	res := make(chan TransferResult, 10)
 
 	ctx, cancelRemainingDownloads := context.WithCancel(context.Background())
 	for n := 0; n <= threads; n++ {
 		go runDownload(ctx, indexes, res)
 	}
 
-	startTime = time.Now()
-	downloads := runAndCollectResults(indexes, res)
+	recorder := startIntervalRecorder("GET", warmup)
+	startTime := time.Now().Add(warmup)
+	downloadResults := runAndCollectResults(indexes, res, recorder)
 	cancelRemainingDownloads()
+	checkSchedule("GET")
 	downloadTime := time.Now().Sub(startTime).Seconds()
 
-	var successfulDownloads int
-	var failedDownloads int
-	var downloadedBytes uint64
-	totalDuration = 0
-	var downloadDurations []float64
-	for _, d := range downloads {
-		if d.Error != nil {
-			failedDownloads++
-		} else {
-			successfulDownloads++
-			downloadedBytes += object_size
-			totalDuration += d.Duration.Seconds()
-			downloadDurations = append(downloadDurations, d.Duration.Seconds())
-		}
-	}
-	sort.Float64s(downloadDurations)
-
-	if successfulDownloads == 0 {
-		log.Fatal("All downloads failed")
-		fmt.Println("For more information, run again with flag -v.")
+	return phaseRun{
+		Operation: "GET",
+		Time:      downloadTime,
+		Results:   measuredResults(downloadResults, startTime),
+		Intervals: recorder.stop(),
 	}
+}
 
-	mbPs := (float64(downloadedBytes) / downloadTime) / (1000 * 1000)
-
-	fmt.Printf("%-9d%-6v%-11s%-7.2f%-12v%-8v%-6.2f\n",
-		threads, bytefmt.ByteSize(object_size), "GET", downloadTime, successfulDownloads, failedDownloads, mbPs)
-
-	if pauseBetweenPhases {
-		pause()
-	}
+func runHeadPhase() phaseRun {
+	indexes := make(chan int, threads)
+	res := make(chan TransferResult, threads)
 
-	ctx = context.Background()
-	fmt.Println("Deleting test objects")
-	deleteChan := make(chan int)
-	deleteWg := sync.WaitGroup{}
-	deleteWg.Add(threads)
+	ctx, cancelRemainingHeads := context.WithCancel(context.Background())
 	for n := 0; n <= threads; n++ {
-		go func() {
-			defer deleteWg.Done()
-
-			for idx := range deleteChan {
-				_ = client.DoDelete(ctx, idx)
-			}
-		}()
+		go runHead(ctx, indexes, res)
 	}
-	for i, v := range successFulUploadsIDs {
-		deleteChan <- v
-		if i > 0 && i%1000 == 0 {
-			fmt.Printf("%d deletes completed\n", i)
-		}
+
+	recorder := startIntervalRecorder("HEAD", warmup)
+	startTime := time.Now().Add(warmup)
+	headResults := runAndCollectResults(indexes, res, recorder)
+	cancelRemainingHeads()
+	checkSchedule("HEAD")
+	headTime := time.Now().Sub(startTime).Seconds()
+
+	return phaseRun{
+		Operation: "HEAD",
+		Time:      headTime,
+		Results:   measuredResults(headResults, startTime),
+		Intervals: recorder.stop(),
 	}
-	close(deleteChan)
-	deleteWg.Wait()
 }
 
-func runAndCollectResults(indexes chan int, res chan TransferResult) []TransferResult {
+func runAndCollectResults(indexes chan int, res chan TransferResult, recorder *intervalRecorder) []TransferResult {
+	startSchedule()
+
 	var nextId int
-	for nextId = 0; nextId < threads+1; nextId++ {
+	for nextId = 0; nextId < threads+1 && (phaseObjects == 0 || nextId < phaseObjects); nextId++ {
 		indexes <- nextId
 	}
 
 	results := make([]TransferResult, 0, 1000)
-	deadline := time.After(time.Second * time.Duration(duration_secs))
+	deadline := phaseDeadline()
+	completed := 0
 
 Loop:
 	for {
@@ -424,9 +969,19 @@ Loop:
 			break Loop
 		case r := <-res:
 			results = append(results, r)
-			if r.Error != nil {
+			recorder.add(r)
+			// Failed requests are retried, but a corrupted object would
+			// fail again
+			if r.Error != nil && !isCorrupted(r.Error) {
 				indexes <- r.Id
-			} else {
+				continue
+			}
+
+			completed++
+			if phaseObjects > 0 && completed >= phaseObjects {
+				break Loop
+			}
+			if phaseObjects == 0 || nextId < phaseObjects {
 				indexes <- nextId
 				nextId = nextId + 1
 			}
@@ -437,28 +992,30 @@ Loop:
 }
 
 type TransferResult struct {
-	Id       int
-	Duration time.Duration
-	Error    error
+	Id         int
+	Bytes      uint64
+	ObjectSize uint64 // size of the object, of which Bytes may be a range
+	Objects    int    // number of objects listed
+	Start      time.Time
+	Duration   time.Duration
+	Error      error
 }
 
-func runUpload(ctx context.Context, ids chan int, res chan TransferResult) {
-	for id := range ids {
-		reader := bytes.NewReader(object_data)
+func runUpload(ctx context.Context, firstID int, indexes chan int, res chan TransferResult) {
+	for idx := range indexes {
+		id := firstID + idx
+		reader := newPayload(id)
 
-		startTime := time.Now()
+		startTime := waitTurn(ctx, objectSize(id))
 		r := client.DoUpload(ctx, id, reader)
 
+		r.Start = startTime
 		r.Duration = time.Now().Sub(startTime)
-		r.Id = id
+		r.Id = idx
+		r.Bytes = objectSize(id)
+		r.ObjectSize = r.Bytes
 
-		if r.Error != nil && verbose {
-			if strings.Contains(r.Error.Error(), "context canceled") {
-				log.Info(r.Error)
-			} else {
-				log.Error(r.Error)
-			}
-		}
+		logTransferError(r.Error)
 		res <- r
 	}
 }
@@ -466,24 +1023,51 @@ func runUpload(ctx context.Context, ids chan int, res chan TransferResult) {
 func runDownload(ctx context.Context, indexes chan int, res chan TransferResult) {
 	for id := range indexes {
 		idx := successFulUploadsIDs[id%len(successFulUploadsIDs)]
+		// The n-th read of the object, to read consecutive ranges
+		rng := objectRange(idx, id/len(successFulUploadsIDs))
 
-		startTime := time.Now()
-		r := client.DoDownload(ctx, idx)
+		startTime := waitTurn(ctx, rng.Length)
+		r := client.DoDownload(ctx, idx, rng)
 
+		r.Start = startTime
 		r.Duration = time.Now().Sub(startTime)
 		r.Id = id
+		r.Bytes = rng.Length
+		r.ObjectSize = objectSize(idx)
 
-		if r.Error != nil && verbose {
-			if strings.Contains(r.Error.Error(), "context canceled") {
-				log.Info(r.Error)
-			} else {
-				log.Error(r.Error)
-			}
-		}
+		logTransferError(r.Error)
+		res <- r
+	}
+}
+
+func runHead(ctx context.Context, indexes chan int, res chan TransferResult) {
+	for id := range indexes {
+		idx := successFulUploadsIDs[id%len(successFulUploadsIDs)]
+
+		startTime := waitTurn(ctx, 0)
+		r := client.DoHead(ctx, idx)
+
+		r.Start = startTime
+		r.Duration = time.Now().Sub(startTime)
+		r.Id = id
+
+		logTransferError(r.Error)
 		res <- r
 	}
 }
 
+func logTransferError(err error) {
+	if err == nil || !verbose {
+		return
+	}
+
+	if strings.Contains(err.Error(), "context canceled") {
+		log.Info(err)
+	} else {
+		log.Error(err)
+	}
+}
+
 func pause() {
 	fmt.Print("Press 'Enter' to continue to the next phase")
 	_, _ = bufio.NewReader(os.Stdin).ReadBytes('\n')
@@ -0,0 +1,359 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type memObject struct {
+	Data         []byte
+	ETag         string
+	LastModified time.Time
+}
+
+// memStore is a concurrency safe in-memory object store, shared by the mem
+// protocol and the embedded S3 server
+type memStore struct {
+	mu      sync.RWMutex
+	objects map[string]*memObject
+
+	// Sorted keys for listings, rebuilt after objects are added or removed
+	sortedKeys []string
+	sorted     bool
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		objects: make(map[string]*memObject),
+	}
+}
+
+func (s *memStore) put(key string, obj *memObject) {
+	s.mu.Lock()
+	if _, ok := s.objects[key]; !ok {
+		s.sorted = false
+	}
+	s.objects[key] = obj
+	s.mu.Unlock()
+}
+
+func (s *memStore) get(key string) (*memObject, bool) {
+	s.mu.RLock()
+	obj, ok := s.objects[key]
+	s.mu.RUnlock()
+	return obj, ok
+}
+
+// list returns, in lexicographic order, at most max keys starting with prefix
+// and sorting after the key after, and whether more keys follow them
+func (s *memStore) list(prefix, after string, max int) ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.sorted {
+		s.sortedKeys = s.sortedKeys[:0]
+		for key := range s.objects {
+			s.sortedKeys = append(s.sortedKeys, key)
+		}
+		sort.Strings(s.sortedKeys)
+		s.sorted = true
+	}
+
+	start := sort.SearchStrings(s.sortedKeys, prefix)
+	if after >= prefix {
+		start = sort.SearchStrings(s.sortedKeys, after+"\x00")
+	}
+
+	var keys []string
+	for _, key := range s.sortedKeys[start:] {
+		if !strings.HasPrefix(key, prefix) {
+			return keys, false
+		}
+		if len(keys) == max {
+			return keys, true
+		}
+		keys = append(keys, key)
+	}
+	return keys, false
+}
+
+func (s *memStore) delete(key string) bool {
+	s.mu.Lock()
+	_, ok := s.objects[key]
+	if ok {
+		delete(s.objects, key)
+		s.sorted = false
+	}
+	s.mu.Unlock()
+	return ok
+}
+
+// count returns the number of objects whose key starts with prefix
+func (s *memStore) count(prefix string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// bandwidthLimiter caps the aggregate throughput of all the transfers: each
+// transfer reserves the time needed to send its bytes at the given rate,
+// after the transfers that came before it
+type bandwidthLimiter struct {
+	mu   sync.Mutex
+	rate float64 // bytes per second
+	next time.Time
+}
+
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	until := l.next
+	l.mu.Unlock()
+
+	return sleepContext(ctx, until.Sub(now))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// MemUploader keeps objects in process memory. It has no network or disk
+// in the way, so it measures the overhead of the benchmark itself, and it
+// can simulate a slow or unreliable storage with injected latency, a
+// bandwidth cap and random failures.
+type MemUploader struct {
+	Store     *memStore
+	Bucket    string
+	Latency   time.Duration
+	ErrorRate float64
+	limiter   *bandwidthLimiter
+}
+
+func NewMemUploader(latency time.Duration, bandwidth uint64, errorRate float64) *MemUploader {
+	u := &MemUploader{
+		Store:     newMemStore(),
+		Latency:   latency,
+		ErrorRate: errorRate,
+	}
+
+	if bandwidth > 0 {
+		u.limiter = &bandwidthLimiter{rate: float64(bandwidth)}
+	}
+	return u
+}
+
+func (u *MemUploader) Prepare(bucket string) error {
+	u.Bucket = bucket
+	return nil
+}
+
+func (u *MemUploader) key(id int) string {
+	return fmt.Sprintf("%s/%s-%d", u.Bucket, objPrefix, id)
+}
+
+// simulate waits for the injected latency and bandwidth, then fails the
+// request at the configured error rate
+func (u *MemUploader) simulate(ctx context.Context, size int) error {
+	if err := sleepContext(ctx, u.Latency); err != nil {
+		return err
+	}
+
+	if u.limiter != nil {
+		if err := u.limiter.wait(ctx, size); err != nil {
+			return err
+		}
+	}
+
+	if u.ErrorRate > 0 && rand.Float64() < u.ErrorRate {
+		return fmt.Errorf("injected failure")
+	}
+	return nil
+}
+
+// DoCopy shares the data of the source object, which is never modified
+func (u *MemUploader) DoCopy(ctx context.Context, srcID, dstID int) (result TransferResult) {
+	srcKey, dstKey := u.key(srcID), u.key(dstID)
+
+	obj, ok := u.Store.get(srcKey)
+	if !ok {
+		result.Error = fmt.Errorf("error copying object %s: no such object", srcKey)
+		return
+	}
+
+	if err := u.simulate(ctx, 0); err != nil {
+		result.Error = fmt.Errorf("error copying object %s to %s: %v", srcKey, dstKey, err)
+		return
+	}
+
+	u.Store.put(dstKey, &memObject{Data: obj.Data, ETag: obj.ETag, LastModified: time.Now()})
+	return
+}
+
+func (u *MemUploader) DoDelete(ctx context.Context, id int) error {
+	key := u.key(id)
+
+	err := u.simulate(ctx, 0)
+	if err == nil && !u.Store.delete(key) {
+		err = fmt.Errorf("no such object")
+	}
+
+	if err != nil {
+		log.Errorf("Error deleting object %s: %v", key, err)
+	}
+	return err
+}
+
+// DoBatchDelete pays the injected latency once for all the objects
+func (u *MemUploader) DoBatchDelete(ctx context.Context, ids []int) (results []TransferResult) {
+	err := u.simulate(ctx, 0)
+	if err != nil {
+		err = fmt.Errorf("error deleting %d objects: %v", len(ids), err)
+		log.Error(err)
+	}
+
+	for _, id := range ids {
+		r := TransferResult{Id: id, Error: err}
+		if err == nil && !u.Store.delete(u.key(id)) {
+			r.Error = fmt.Errorf("error deleting object %s: no such object", u.key(id))
+		}
+		results = append(results, r)
+	}
+	return
+}
+
+func (u *MemUploader) DoDownload(ctx context.Context, id int, rng byteRange) (result TransferResult) {
+	key := u.key(id)
+
+	obj, ok := u.Store.get(key)
+	if !ok {
+		result.Error = fmt.Errorf("error downloading object %s: no such object", key)
+		return
+	}
+
+	data := obj.Data
+	if !rng.whole {
+		if rng.Offset+rng.Length > uint64(len(data)) {
+			result.Error = fmt.Errorf("error downloading object %s: invalid range %s", key, rng.header())
+			return
+		}
+		data = data[rng.Offset : rng.Offset+rng.Length]
+	}
+
+	if err := u.simulate(ctx, len(data)); err != nil {
+		result.Error = fmt.Errorf("error downloading object %s: %v", key, err)
+		return
+	}
+
+	// Receive response
+	result.Error = receiveObject(id, rng, bytes.NewReader(data))
+	return
+}
+
+func (u *MemUploader) DoHead(ctx context.Context, id int) (result TransferResult) {
+	key := u.key(id)
+
+	obj, ok := u.Store.get(key)
+	if !ok {
+		result.Error = fmt.Errorf("error reading metadata of object %s: no such object", key)
+		return
+	}
+
+	if err := u.simulate(ctx, 0); err != nil {
+		result.Error = fmt.Errorf("error reading metadata of object %s: %v", key, err)
+		return
+	}
+
+	result.Error = checkObjectSize(id, int64(len(obj.Data)))
+	return
+}
+
+// DoList counts the objects, paying the injected latency once per page
+func (u *MemUploader) DoList(ctx context.Context, prefix string) (result TransferResult) {
+	n := u.Store.count(u.Bucket + "/" + prefix)
+
+	for listed := 0; ; listed += listPageSize {
+		if err := u.simulate(ctx, 0); err != nil {
+			result.Error = fmt.Errorf("error listing objects %s: %v", prefix, err)
+			return
+		}
+		if listed+listPageSize >= n {
+			break
+		}
+	}
+
+	result.Objects = n
+	return
+}
+
+func (u *MemUploader) DoUpload(ctx context.Context, id int, data io.ReadSeeker) (result TransferResult) {
+	result.Id = id
+
+	key := u.key(id)
+
+	buf, err := ioutil.ReadAll(data)
+	if err != nil {
+		result.Error = fmt.Errorf("error uploading object %s: %v", key, err)
+		return
+	}
+
+	if err := u.simulate(ctx, len(buf)); err != nil {
+		result.Error = fmt.Errorf("error uploading object %s: %v", key, err)
+		return
+	}
+
+	u.Store.put(key, &memObject{Data: buf, LastModified: time.Now()})
+	return
+}
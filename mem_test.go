@@ -0,0 +1,87 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemLatency(t *testing.T) {
+	u := NewMemUploader(50*time.Millisecond, 0, 0)
+
+	start := time.Now()
+	if r := u.DoUpload(context.Background(), 0, bytes.NewReader(make([]byte, 1024))); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("upload took %v, expected at least the latency of 50ms", elapsed)
+	}
+
+	// The end of the phase interrupts the requests
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if r := u.DoUpload(ctx, 1, bytes.NewReader(make([]byte, 1024))); r.Error == nil {
+		t.Error("upload not interrupted by its context")
+	}
+}
+
+func TestMemBandwidth(t *testing.T) {
+	u := NewMemUploader(0, 1024*1024, 0)
+
+	// 320K at 1M/s, the transfers waiting for the ones before them
+	start := time.Now()
+	for id := 0; id < 5; id++ {
+		if r := u.DoUpload(context.Background(), id, bytes.NewReader(make([]byte, 64*1024))); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("320K uploaded in %v at 1M/s, expected at least 300ms", elapsed)
+	}
+}
+
+func TestMemErrorRate(t *testing.T) {
+	u := NewMemUploader(0, 0, 0)
+
+	for _, rate := range []float64{0, 0.5, 1} {
+		u.ErrorRate = rate
+
+		const requests = 2000
+		failed := 0
+		for i := 0; i < requests; i++ {
+			if err := u.simulate(context.Background(), 0); err != nil {
+				failed++
+			}
+		}
+
+		// Within 5 standard deviations for 0.5
+		expected, margin := int(rate*requests), 0
+		if rate == 0.5 {
+			margin = 115
+		}
+		if failed < expected-margin || failed > expected+margin {
+			t.Errorf("error rate %g: %d of %d requests failed", rate, failed, requests)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Upper bounds of the buckets of the latency histograms, in seconds
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Value of the backend label: the target, or else the protocol, of the run
+var metricsBackend string
+
+// metrics is nil unless the metrics are served with -metrics-listen
+var metrics *metricsRegistry
+
+type metricsKey struct {
+	backend string
+	phase   string
+}
+
+type errorKey struct {
+	metricsKey
+	class string
+}
+
+// phaseMetrics holds the counters and the latency histogram of the
+// successful requests of a phase
+type phaseMetrics struct {
+	operations uint64
+	bytes      uint64
+	// Cumulative counts, one per bucket plus +Inf
+	buckets    []uint64
+	latencySum float64
+}
+
+// metricsRegistry accumulates the results of the requests since the start
+// of the process, it is safe for concurrent use
+type metricsRegistry struct {
+	mu     sync.Mutex
+	keys   []metricsKey
+	phases map[metricsKey]*phaseMetrics
+	errors map[errorKey]uint64
+	// The error classes in the order they first occurred
+	errorKeys []errorKey
+}
+
+// startMetricsServer serves the metrics in the Prometheus text format on
+// addr, under /metrics, for the rest of the process
+func startMetricsServer(addr string) error {
+	if metrics != nil {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", addr, err)
+	}
+	metrics = &metricsRegistry{
+		phases: make(map[metricsKey]*phaseMetrics),
+		errors: make(map[errorKey]uint64),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.write(w)
+	})
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Errorf("error serving metrics: %v", err)
+		}
+	}()
+	fmt.Printf("%-15shttp://%s/metrics\n", "Metrics", l.Addr())
+	return nil
+}
+
+// recordMetrics adds the result of a request of phase to the metrics, if
+// they are served
+func recordMetrics(phase string, r TransferResult) {
+	if metrics == nil {
+		return
+	}
+
+	k := metricsKey{backend: metricsBackend, phase: phase}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	if r.Error != nil {
+		ek := errorKey{k, errorClass(r.Error)}
+		if _, ok := metrics.errors[ek]; !ok {
+			metrics.errorKeys = append(metrics.errorKeys, ek)
+		}
+		metrics.errors[ek]++
+		return
+	}
+
+	m, ok := metrics.phases[k]
+	if !ok {
+		m = &phaseMetrics{buckets: make([]uint64, len(latencyBuckets)+1)}
+		metrics.phases[k] = m
+		metrics.keys = append(metrics.keys, k)
+	}
+	m.operations++
+	m.bytes += r.Bytes
+
+	latency := r.Duration.Seconds()
+	m.latencySum += latency
+	for i, le := range latencyBuckets {
+		if latency <= le {
+			m.buckets[i]++
+		}
+	}
+	m.buckets[len(latencyBuckets)]++
+}
+
+// write writes the metrics in the Prometheus text format
+func (m *metricsRegistry) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := bufio.NewWriter(w)
+	defer b.Flush()
+
+	fmt.Fprintln(b, "# HELP rs_benchmark_operations_total Number of successful requests.")
+	fmt.Fprintln(b, "# TYPE rs_benchmark_operations_total counter")
+	for _, k := range m.keys {
+		fmt.Fprintf(b, "rs_benchmark_operations_total{%s} %d\n", k.labels(), m.phases[k].operations)
+	}
+
+	fmt.Fprintln(b, "# HELP rs_benchmark_bytes_total Number of bytes transferred by the successful requests.")
+	fmt.Fprintln(b, "# TYPE rs_benchmark_bytes_total counter")
+	for _, k := range m.keys {
+		fmt.Fprintf(b, "rs_benchmark_bytes_total{%s} %d\n", k.labels(), m.phases[k].bytes)
+	}
+
+	fmt.Fprintln(b, "# HELP rs_benchmark_errors_total Number of failed requests, by class of error.")
+	fmt.Fprintln(b, "# TYPE rs_benchmark_errors_total counter")
+	for _, k := range m.errorKeys {
+		fmt.Fprintf(b, "rs_benchmark_errors_total{%s,class=%s} %d\n", k.labels(), labelValue(k.class), m.errors[k])
+	}
+
+	fmt.Fprintln(b, "# HELP rs_benchmark_latency_seconds Latency of the successful requests.")
+	fmt.Fprintln(b, "# TYPE rs_benchmark_latency_seconds histogram")
+	for _, k := range m.keys {
+		p := m.phases[k]
+		labels := k.labels()
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(b, "rs_benchmark_latency_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels, strconv.FormatFloat(le, 'g', -1, 64), p.buckets[i])
+		}
+		fmt.Fprintf(b, "rs_benchmark_latency_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, p.buckets[len(latencyBuckets)])
+		fmt.Fprintf(b, "rs_benchmark_latency_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(p.latencySum, 'g', -1, 64))
+		fmt.Fprintf(b, "rs_benchmark_latency_seconds_count{%s} %d\n", labels, p.operations)
+	}
+}
+
+func (k metricsKey) labels() string {
+	return fmt.Sprintf("backend=%s,phase=%s", labelValue(k.backend), labelValue(k.phase))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelValue(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// HTTP status codes as printed by the SDKs, e.g. "status code: 503",
+// "Status: 404" or "Error 403"
+var statusCodePattern = regexp.MustCompile(`(?i)(?:status code:|status:|error) ([1-5][0-9][0-9])\b`)
+
+// errorClass sorts the errors of the requests in a few classes. The clients
+// only keep the text of the errors of the SDKs, so they are told apart by
+// their message.
+func errorClass(err error) string {
+	if isCorrupted(err) {
+		return "corrupted"
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "context canceled"):
+		return "canceled"
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "deadline exceeded"):
+		return "timeout"
+	}
+
+	if m := statusCodePattern.FindStringSubmatch(msg); m != nil {
+		switch code := m[1]; {
+		case code == "404":
+			return "not_found"
+		case code == "429" || code == "503":
+			return "throttled"
+		case code[0] == '4':
+			return "client"
+		case code[0] == '5':
+			return "server"
+		}
+	}
+
+	switch {
+	case strings.Contains(msg, "connection refused"), strings.Contains(msg, "connection reset"),
+		strings.Contains(msg, "no such host"), strings.Contains(msg, "broken pipe"), strings.Contains(msg, "eof"):
+		return "connection"
+	case strings.Contains(msg, "no such"), strings.Contains(msg, "not exist"), strings.Contains(msg, "not found"):
+		return "not_found"
+	case strings.Contains(msg, "slowdown"), strings.Contains(msg, "throttl"):
+		return "throttled"
+	}
+	return "other"
+}
@@ -0,0 +1,102 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A sample of the Prometheus text format: name{labels} value
+var samplePattern = regexp.MustCompile(`^[a-z_]+\{([a-z]+="(?:[^"\\]|\\.)*",?)+\} [0-9.e+-]+$`)
+
+func TestMetricsExposition(t *testing.T) {
+	saved, savedBackend := metrics, metricsBackend
+	defer func() { metrics, metricsBackend = saved, savedBackend }()
+
+	metrics = &metricsRegistry{
+		phases: make(map[metricsKey]*phaseMetrics),
+		errors: make(map[errorKey]uint64),
+	}
+	metricsBackend = `my "store"`
+
+	recordMetrics("PUT", TransferResult{Bytes: 1024, Duration: 2 * time.Millisecond})
+	recordMetrics("PUT", TransferResult{Bytes: 1024, Duration: 500 * time.Millisecond})
+	recordMetrics("PUT", TransferResult{Bytes: 1024, Duration: 2 * time.Minute})
+	recordMetrics("PUT", TransferResult{Error: errors.New("error uploading object: status code: 503")})
+	recordMetrics("GET", TransferResult{Error: &corruptedObjectError{Id: 1}})
+
+	var buf bytes.Buffer
+	metrics.write(&buf)
+	out := buf.String()
+
+	for _, line := range []string{
+		`# TYPE rs_benchmark_operations_total counter`,
+		`rs_benchmark_operations_total{backend="my \"store\"",phase="PUT"} 3`,
+		`rs_benchmark_bytes_total{backend="my \"store\"",phase="PUT"} 3072`,
+		`rs_benchmark_errors_total{backend="my \"store\"",phase="PUT",class="throttled"} 1`,
+		`rs_benchmark_errors_total{backend="my \"store\"",phase="GET",class="corrupted"} 1`,
+		`# TYPE rs_benchmark_latency_seconds histogram`,
+		`rs_benchmark_latency_seconds_bucket{backend="my \"store\"",phase="PUT",le="0.001"} 0`,
+		`rs_benchmark_latency_seconds_bucket{backend="my \"store\"",phase="PUT",le="0.0025"} 1`,
+		`rs_benchmark_latency_seconds_bucket{backend="my \"store\"",phase="PUT",le="0.5"} 2`,
+		`rs_benchmark_latency_seconds_bucket{backend="my \"store\"",phase="PUT",le="60"} 2`,
+		`rs_benchmark_latency_seconds_bucket{backend="my \"store\"",phase="PUT",le="+Inf"} 3`,
+		`rs_benchmark_latency_seconds_sum{backend="my \"store\"",phase="PUT"} 120.502`,
+		`rs_benchmark_latency_seconds_count{backend="my \"store\"",phase="PUT"} 3`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if !strings.HasPrefix(line, "# HELP ") && !strings.HasPrefix(line, "# TYPE ") && !samplePattern.MatchString(line) {
+			t.Errorf("invalid line %q", line)
+		}
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	for msg, expected := range map[string]string{
+		"error downloading object: context canceled":                          "canceled",
+		"error uploading object: context deadline exceeded":                   "timeout",
+		"NotFound: Not Found\n\tstatus code: 404, request id: 1":              "not_found",
+		"SlowDown: Please reduce your request rate\n\tstatus code: 503":       "throttled",
+		"AccessDenied: Access Denied\n\tstatus code: 403":                     "client",
+		"InternalError: We encountered an internal error\n\tstatus code: 500": "server",
+		"googleapi: Error 429: rate limit exceeded":                           "throttled",
+		"dial tcp 127.0.0.1:9000: connect: connection refused":                "connection",
+		"error downloading object bucket/Object-1: no such object":            "not_found",
+		"something else": "other",
+	} {
+		if class := errorClass(errors.New(msg)); class != expected {
+			t.Errorf("%q classified as %s, expected %s", msg, class, expected)
+		}
+	}
+	if class := errorClass(&corruptedObjectError{Id: 1}); class != "corrupted" {
+		t.Errorf("corrupted object classified as %s", class)
+	}
+}
@@ -0,0 +1,377 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations of the mixed workload
+const (
+	mixGet    = "get"
+	mixPut    = "put"
+	mixDelete = "delete"
+)
+
+var mixOperations = []string{mixGet, mixPut, mixDelete}
+
+// Weight of each operation of the mixed workload, nil when the phases are
+// run one after the other
+var mixRatios map[string]int
+
+// parseMix parses ratios like "get=70,put=25,delete=5"
+func parseMix(arg string) (map[string]int, error) {
+	ratios := make(map[string]int)
+	total := 0
+
+	for _, field := range strings.Split(arg, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected operation=weight, got %q", field)
+		}
+
+		op := strings.ToLower(kv[0])
+		if op != mixGet && op != mixPut && op != mixDelete {
+			return nil, fmt.Errorf("unknown operation %q: available: get, put, delete", kv[0])
+		}
+
+		weight, err := strconv.Atoi(kv[1])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q for %s", kv[1], op)
+		}
+
+		ratios[op] = weight
+		total += weight
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("at least one operation must have a positive weight")
+	}
+	return ratios, nil
+}
+
+func pickMixOperation(ratios map[string]int) string {
+	total := 0
+	for _, op := range mixOperations {
+		total += ratios[op]
+	}
+
+	n := rand.Intn(total)
+	for _, op := range mixOperations {
+		if n < ratios[op] {
+			return op
+		}
+		n -= ratios[op]
+	}
+	return mixGet
+}
+
+// objectPool tracks the objects that currently exist during a mixed phase.
+// Objects being read are not deleted, so that deletes never make a
+// concurrent read fail.
+type objectPool struct {
+	mu      sync.Mutex
+	ids     []int
+	index   map[int]int
+	readers map[int]int
+	// Number of reads of each object, to read consecutive ranges
+	reads  map[int]int
+	nextID int
+	// The objects being uploaded, and the ones whose upload was interrupted
+	// by the end of the phase, which may exist
+	uploading map[int]bool
+}
+
+func newObjectPool(ids []int) *objectPool {
+	p := &objectPool{
+		index:     make(map[int]int),
+		readers:   make(map[int]int),
+		reads:     make(map[int]int),
+		uploading: make(map[int]bool),
+	}
+	for _, id := range ids {
+		p.addLocked(id)
+		if id >= p.nextID {
+			p.nextID = id + 1
+		}
+	}
+	return p
+}
+
+func (p *objectPool) addLocked(id int) {
+	p.index[id] = len(p.ids)
+	p.ids = append(p.ids, id)
+}
+
+func (p *objectPool) removeLocked(id int) {
+	i := p.index[id]
+	last := p.ids[len(p.ids)-1]
+	p.ids[i] = last
+	p.index[last] = i
+	p.ids = p.ids[:len(p.ids)-1]
+	delete(p.index, id)
+}
+
+func (p *objectPool) add(id int) {
+	p.mu.Lock()
+	p.addLocked(id)
+	p.mu.Unlock()
+}
+
+// newID returns the id of an object to upload, which is tracked until
+// uploaded is called
+func (p *objectPool) newID() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := p.nextID
+	p.nextID++
+	p.uploading[id] = true
+	return id
+}
+
+// uploaded adds the object to the pool if its upload succeeded
+func (p *objectPool) uploaded(id int, ok bool) {
+	p.mu.Lock()
+	delete(p.uploading, id)
+	if ok {
+		p.addLocked(id)
+	}
+	p.mu.Unlock()
+}
+
+// interrupted returns the objects whose upload was interrupted
+func (p *objectPool) interrupted() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var ids []int
+	for id := range p.uploading {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// acquire picks a random object to read, which must be released afterwards.
+// It also returns the number of previous reads of the object.
+func (p *objectPool) acquire() (int, int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.ids) == 0 {
+		return 0, 0, false
+	}
+	id := p.ids[rand.Intn(len(p.ids))]
+	p.readers[id]++
+	n := p.reads[id]
+	p.reads[id]++
+	return id, n, true
+}
+
+func (p *objectPool) release(id int) {
+	p.mu.Lock()
+	p.readers[id]--
+	if p.readers[id] == 0 {
+		delete(p.readers, id)
+	}
+	p.mu.Unlock()
+}
+
+// take removes a random object which is not being read from the pool
+func (p *objectPool) take() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	const attempts = 10
+	for i := 0; i < attempts && len(p.ids) > 0; i++ {
+		id := p.ids[rand.Intn(len(p.ids))]
+		if p.readers[id] == 0 {
+			p.removeLocked(id)
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func (p *objectPool) list() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]int(nil), p.ids...)
+}
+
+func mixPhaseName(op string) string {
+	return "MIX-" + strings.ToUpper(op)
+}
+
+type mixedResult struct {
+	Operation string
+	TransferResult
+}
+
+// runMixed runs the mixed workload against the objects uploaded by the PUT
+// phase, returning one phase result per operation. The phase ends after
+// phaseObjects requests of any operation when set. successFulUploadsIDs is
+// updated with the objects that exist at the end of the phase.
+func runMixed() []phaseRun {
+	pool := newObjectPool(successFulUploadsIDs)
+	res := make(chan mixedResult, threads)
+
+	startSchedule()
+	ctx, cancelRemainingRequests := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	wg.Add(threads + 1)
+	for n := 0; n <= threads; n++ {
+		go func() {
+			defer wg.Done()
+			runMixedWorker(ctx, pool, res)
+		}()
+	}
+
+	results := make(map[string][]TransferResult)
+	recorders := make(map[string]*intervalRecorder)
+	for _, op := range mixOperations {
+		if mixRatios[op] > 0 {
+			recorders[op] = startIntervalRecorder(mixPhaseName(op), warmup)
+		}
+	}
+	deadline := phaseDeadline()
+
+	startTime := time.Now().Add(warmup)
+	completed := 0
+Loop:
+	for {
+		select {
+		case <-deadline:
+			break Loop
+		case r := <-res:
+			results[r.Operation] = append(results[r.Operation], r.TransferResult)
+			if recorder, ok := recorders[r.Operation]; ok {
+				recorder.add(r.TransferResult)
+			}
+
+			completed++
+			if phaseObjects > 0 && completed >= phaseObjects {
+				break Loop
+			}
+		}
+	}
+	cancelRemainingRequests()
+	elapsed := time.Now().Sub(startTime).Seconds()
+	checkSchedule("MIX")
+
+	// The uploads still in flight may complete, their objects must be
+	// deleted too
+	wg.Wait()
+	for _, id := range pool.interrupted() {
+		if client.DoHead(context.Background(), id).Error == nil {
+			pool.add(id)
+		}
+	}
+	successFulUploadsIDs = pool.list()
+
+	var phases []phaseRun
+	for _, op := range mixOperations {
+		if mixRatios[op] > 0 {
+			phases = append(phases, phaseRun{
+				Operation: mixPhaseName(op),
+				Time:      elapsed,
+				Results:   measuredResults(results[op], startTime),
+				Intervals: recorders[op].stop(),
+			})
+		}
+	}
+	return phases
+}
+
+func runMixedWorker(ctx context.Context, pool *objectPool, res chan mixedResult) {
+	for ctx.Err() == nil {
+		op := pickMixOperation(mixRatios)
+
+		var id, reads int
+		var ok bool
+		switch op {
+		case mixGet:
+			id, reads, ok = pool.acquire()
+		case mixDelete:
+			id, ok = pool.take()
+		}
+		if !ok {
+			// Nothing to read or delete, grow the pool instead
+			op = mixPut
+		}
+		if op == mixPut {
+			id = pool.newID()
+		}
+
+		var bytes uint64
+		var rng byteRange
+		switch op {
+		case mixGet:
+			rng = objectRange(id, reads)
+			bytes = rng.Length
+		case mixPut:
+			bytes = objectSize(id)
+		}
+
+		var r TransferResult
+		startTime := waitTurn(ctx, bytes)
+		switch op {
+		case mixGet:
+			r = client.DoDownload(ctx, id, rng)
+			pool.release(id)
+		case mixPut:
+			r = client.DoUpload(ctx, id, newPayload(id))
+		case mixDelete:
+			r.Error = client.DoDelete(ctx, id)
+		}
+		r.Start = startTime
+		r.Duration = time.Now().Sub(startTime)
+		r.Id = id
+		r.Bytes = bytes
+		if op != mixDelete {
+			r.ObjectSize = objectSize(id)
+		}
+
+		// An upload interrupted by the end of the phase stays tracked, as
+		// its object may exist
+		if op == mixPut && (r.Error == nil || ctx.Err() == nil) {
+			pool.uploaded(id, r.Error == nil)
+		}
+		if r.Error != nil && op == mixDelete {
+			// The object might still exist, keep it for the cleanup
+			pool.add(id)
+		}
+
+		logTransferError(r.Error)
+
+		select {
+		case res <- mixedResult{Operation: op, TransferResult: r}:
+		case <-ctx.Done():
+		}
+	}
+}
@@ -0,0 +1,59 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMixedRanges(t *testing.T) {
+	args := []string{"-protocol", "mem", "-b", "test", "-d", "1", "-t", "2", "-z", "4K",
+		"-mix", "get=1", "-range-size", "1K"}
+	report := runBenchmark(args, configure(args))
+
+	for _, phase := range report.Loops[0].Phases {
+		if phase.Operation != mixPhaseName(mixGet) {
+			continue
+		}
+		if phase.Successful == 0 || phase.Bytes != uint64(phase.Successful)*1024 {
+			t.Errorf("%d reads of %d bytes, expected 1K ranges", phase.Successful, phase.Bytes)
+		}
+		return
+	}
+	t.Error("no MIX-GET phase")
+}
+
+// TestObjectPoolInterruptedUploads checks that the objects of the uploads
+// which didn't complete are kept for the cleanup
+func TestObjectPoolInterruptedUploads(t *testing.T) {
+	pool := newObjectPool([]int{0})
+	uploaded, failed, interrupted := pool.newID(), pool.newID(), pool.newID()
+	pool.uploaded(uploaded, true)
+	pool.uploaded(failed, false)
+
+	if ids := pool.list(); len(ids) != 2 {
+		t.Errorf("pool %v, expected the existing and the uploaded objects", ids)
+	}
+	if ids := pool.interrupted(); !reflect.DeepEqual(ids, []int{interrupted}) {
+		t.Errorf("interrupted uploads %v, expected [%d]", ids, interrupted)
+	}
+}
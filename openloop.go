@@ -0,0 +1,111 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Target load of the open loop mode, in requests and bytes per second. The
+// engine runs in closed loop, each worker sending its next request as soon
+// as the previous one completes, when both are 0.
+var targetRate float64
+var targetBandwidth uint64
+
+// Delay behind the schedule at the end of a phase above which the target
+// load is reported as not reached
+const maxScheduleLag = time.Second
+
+// requestSchedule hands out the intended start times of the requests of a
+// phase. Unlike a rate limiter it never waits for late requests: when all
+// the workers are busy the next request starts as soon as one is free, and
+// its latency includes the time it spent waiting for it.
+type requestSchedule struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+var schedule requestSchedule
+
+func openLoop() bool {
+	return targetRate > 0 || targetBandwidth > 0
+}
+
+// startSchedule schedules the first request of a phase now
+func startSchedule() {
+	schedule.mu.Lock()
+	schedule.next = time.Now()
+	schedule.mu.Unlock()
+}
+
+// waitTurn waits for the intended start time of the next request, which
+// transfers the given number of bytes, and returns it. In closed loop the
+// request starts right away.
+func waitTurn(ctx context.Context, bytes uint64) time.Time {
+	if !openLoop() {
+		return time.Now()
+	}
+
+	schedule.mu.Lock()
+	start := schedule.next
+	schedule.next = start.Add(requestInterval(bytes))
+	schedule.mu.Unlock()
+
+	_ = sleepContext(ctx, time.Until(start))
+	return start
+}
+
+// requestInterval returns the time between the start of a request and the
+// start of the next one, the longest of the ones required by each target
+func requestInterval(bytes uint64) time.Duration {
+	var interval time.Duration
+	if targetRate > 0 {
+		interval = time.Duration(float64(time.Second) / targetRate)
+	}
+	if targetBandwidth > 0 {
+		transfer := time.Duration(float64(bytes) / float64(targetBandwidth) * float64(time.Second))
+		if transfer > interval {
+			interval = transfer
+		}
+	}
+	return interval
+}
+
+// checkSchedule warns when the requests of the phase fell behind their
+// schedule, the target load being more than the storage or the workers can
+// sustain
+func checkSchedule(operation string) {
+	if !openLoop() {
+		return
+	}
+
+	schedule.mu.Lock()
+	lag := time.Since(schedule.next)
+	schedule.mu.Unlock()
+
+	if lag > maxScheduleLag {
+		fmt.Printf("%s did not reach the target load, requests were %.1fs behind schedule: "+
+			"the storage is saturated or more threads are needed\n", operation, lag.Seconds())
+	}
+}
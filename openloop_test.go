@@ -0,0 +1,85 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRequestInterval(t *testing.T) {
+	defer func() { targetRate, targetBandwidth = 0, 0 }()
+
+	for _, test := range []struct {
+		rate      float64
+		bandwidth uint64
+		bytes     uint64
+		expected  time.Duration
+	}{
+		{10, 0, 1024, 100 * time.Millisecond},
+		{0, 1024 * 1024, 512 * 1024, 500 * time.Millisecond},
+		// The longest interval wins
+		{10, 1024 * 1024, 512 * 1024, 500 * time.Millisecond},
+		{10, 1024 * 1024, 1024, 100 * time.Millisecond},
+	} {
+		targetRate, targetBandwidth = test.rate, test.bandwidth
+		if interval := requestInterval(test.bytes); interval != test.expected {
+			t.Errorf("rate %g, bandwidth %d, %d bytes: interval %v, expected %v",
+				test.rate, test.bandwidth, test.bytes, interval, test.expected)
+		}
+	}
+}
+
+func TestOpenLoopPacing(t *testing.T) {
+	defer func() { targetRate = 0 }()
+	ctx := context.Background()
+
+	targetRate = 50
+	startSchedule()
+	first := waitTurn(ctx, 0)
+	for i := 1; i < 10; i++ {
+		if start := waitTurn(ctx, 0); start.Sub(first) != time.Duration(i)*20*time.Millisecond {
+			t.Errorf("request %d scheduled %v after the first, expected %v", i, start.Sub(first), time.Duration(i)*20*time.Millisecond)
+		}
+	}
+	if elapsed := time.Since(first); elapsed < 180*time.Millisecond {
+		t.Errorf("10 requests at 50/s sent in %v, expected at least 180ms", elapsed)
+	}
+
+	// A late request starts right away, and its latency counts from its
+	// intended start
+	time.Sleep(100 * time.Millisecond)
+	before := time.Now()
+	start := waitTurn(ctx, 0)
+	if waited := time.Since(before); waited > 50*time.Millisecond {
+		t.Errorf("late request waited %v", waited)
+	}
+	if lag := before.Sub(start); lag < 50*time.Millisecond {
+		t.Errorf("late request scheduled %v before it was sent, expected at least 50ms", lag)
+	}
+
+	// Closed loop
+	targetRate = 0
+	if start := waitTurn(ctx, 0); time.Since(start) > 50*time.Millisecond {
+		t.Errorf("closed loop request scheduled %v ago", time.Since(start))
+	}
+}
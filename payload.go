@@ -0,0 +1,310 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+)
+
+// Payload modes
+const (
+	// every object has the same random content, a prefix of object_data,
+	// repeated in the objects larger than it
+	payloadRandom = "random"
+	// the content of every object is generated from the seed and its id
+	payloadUnique = "unique"
+	// every object is filled with zeros
+	payloadZeros = "zeros"
+)
+
+var payloadMode = payloadRandom
+var payloadSeed int64
+
+// The random content shared by the objects is at most this large, so that
+// the maximum size of a distribution doesn't have to fit in memory. It is a
+// multiple of compressionBlockSize.
+const maxPayloadDataSize = 64 * 1024 * 1024
+
+// Target compression ratio of the random payloads, 1 for incompressible
+var compressionRatio = 1.0
+
+// The payloads are made compressible by filling a fraction of every block
+// with zeros
+const compressionBlockSize = 4096
+
+var verifyDownloads bool
+
+// Expected digest of the objects, by id and size in unique mode, else by
+// size
+var payloadDigests sync.Map
+
+type payloadDigestKey struct {
+	id   int
+	size uint64
+}
+
+type corruptedObjectError struct {
+	Id int
+}
+
+func (e *corruptedObjectError) Error() string {
+	return fmt.Sprintf("corrupted content for object %d", e.Id)
+}
+
+func isCorrupted(err error) bool {
+	_, ok := err.(*corruptedObjectError)
+	return ok
+}
+
+// newPayload returns the content to upload for object id. The content is
+// never copied: it is either the shared object_data or generated on the fly.
+func newPayload(id int) io.ReadSeeker {
+	switch payloadMode {
+	case payloadUnique:
+		return io.NewSectionReader(newUniquePayload(id), 0, int64(objectSize(id)))
+	case payloadZeros:
+		return io.NewSectionReader(zeroPayload{}, 0, int64(objectSize(id)))
+	default:
+		size := objectSize(id)
+		if size <= uint64(len(object_data)) {
+			return bytes.NewReader(object_data[:size])
+		}
+		return io.NewSectionReader(repeatedPayload(object_data), 0, int64(size))
+	}
+}
+
+// initPayload prepares the content shared by all the objects
+func initPayload() {
+	if payloadMode == payloadRandom {
+		size := object_size
+		if size > maxPayloadDataSize {
+			size = maxPayloadDataSize
+		}
+		object_data = make([]byte, size)
+		rand.New(rand.NewSource(payloadSeed)).Read(object_data)
+		makeCompressible(object_data, 0)
+	}
+}
+
+// makeCompressible zeroes the end of every block of b, which starts at
+// offset off of the object, so that compressing it gives compressionRatio
+func makeCompressible(b []byte, off int64) {
+	if compressionRatio <= 1 {
+		return
+	}
+
+	randomBytes := int64(float64(compressionBlockSize) / compressionRatio)
+	for n := 0; n < len(b); {
+		pos := (off + int64(n)) % compressionBlockSize
+		if pos < randomBytes {
+			n += int(randomBytes - pos)
+			continue
+		}
+
+		end := n + int(compressionBlockSize-pos)
+		if end > len(b) {
+			end = len(b)
+		}
+		for ; n < end; n++ {
+			b[n] = 0
+		}
+	}
+}
+
+// payloadSection returns a reader on length bytes of data starting at
+// offset, used to upload the parts of an object
+func payloadSection(data io.ReadSeeker, offset, length int64) (io.ReadSeeker, error) {
+	if ra, ok := data.(io.ReaderAt); ok {
+		return io.NewSectionReader(ra, offset, length), nil
+	}
+
+	if _, err := data.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(data, buf); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf), nil
+}
+
+// uniquePayload is an endless stream of pseudo-random bytes derived from the
+// seed and the object id, so that every object has different content which
+// can be regenerated at any offset for verification
+type uniquePayload struct {
+	key uint64
+}
+
+func newUniquePayload(id int) *uniquePayload {
+	return &uniquePayload{key: splitmix64(uint64(payloadSeed) ^ splitmix64(uint64(id)))}
+}
+
+func (p *uniquePayload) word(index int64) uint64 {
+	return splitmix64(p.key + uint64(index))
+}
+
+func (p *uniquePayload) ReadAt(b []byte, off int64) (int, error) {
+	var word [8]byte
+
+	n := 0
+	if head := off % 8; head != 0 {
+		binary.LittleEndian.PutUint64(word[:], p.word(off/8))
+		n = copy(b, word[head:])
+	}
+
+	for ; n+8 <= len(b); n += 8 {
+		binary.LittleEndian.PutUint64(b[n:], p.word((off+int64(n))/8))
+	}
+
+	if n < len(b) {
+		binary.LittleEndian.PutUint64(word[:], p.word((off+int64(n))/8))
+		n += copy(b[n:], word[:])
+	}
+
+	makeCompressible(b, off)
+	return n, nil
+}
+
+// repeatedPayload is an endless repetition of its content
+type repeatedPayload []byte
+
+func (p repeatedPayload) ReadAt(b []byte, off int64) (int, error) {
+	n := 0
+	for n < len(b) {
+		n += copy(b[n:], p[(off+int64(n))%int64(len(p)):])
+	}
+	return n, nil
+}
+
+type zeroPayload struct{}
+
+func (zeroPayload) ReadAt(b []byte, off int64) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+// splitmix64 is a fast hash with good avalanche, see
+// http://xorshift.di.unimi.it/splitmix64.c
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// expectedDigest returns the digest of the content uploaded for object id
+func expectedDigest(id int) []byte {
+	// The content of the other payloads only depends on the size. A size
+	// sweep uploads the same ids again with other sizes.
+	var cacheKey interface{} = objectSize(id)
+	if payloadMode == payloadUnique {
+		cacheKey = payloadDigestKey{id, objectSize(id)}
+	}
+
+	if digest, ok := payloadDigests.Load(cacheKey); ok {
+		return digest.([]byte)
+	}
+
+	h := md5.New()
+	_, _ = io.Copy(h, newPayload(id))
+	digest := h.Sum(nil)
+	payloadDigests.Store(cacheKey, digest)
+	return digest
+}
+
+// expectedRangeDigest returns the digest of a range of object id
+func expectedRangeDigest(id int, rng byteRange) []byte {
+	if rng.whole {
+		return expectedDigest(id)
+	}
+
+	h := md5.New()
+	section, err := payloadSection(newPayload(id), int64(rng.Offset), int64(rng.Length))
+	if err == nil {
+		_, _ = io.Copy(h, section)
+	}
+	return h.Sum(nil)
+}
+
+// objectReceiver consumes the content of a downloaded object or range,
+// hashing it when downloads are verified
+type objectReceiver struct {
+	id     int
+	rng    byteRange
+	hasher hash.Hash
+}
+
+func newObjectReceiver(id int, rng byteRange) *objectReceiver {
+	r := &objectReceiver{id: id, rng: rng}
+	if verifyDownloads {
+		r.hasher = md5.New()
+	}
+	return r
+}
+
+func (r *objectReceiver) Write(p []byte) (int, error) {
+	if r.hasher == nil {
+		return ioutil.Discard.Write(p)
+	}
+	return r.hasher.Write(p)
+}
+
+// check validates the size and, when verifying, the content of the object
+func (r *objectReceiver) check(copied int64) error {
+	if uint64(copied) != r.rng.Length {
+		return errors.New("wrong response size")
+	}
+
+	if r.hasher != nil && !bytes.Equal(r.hasher.Sum(nil), expectedRangeDigest(r.id, r.rng)) {
+		return &corruptedObjectError{Id: r.id}
+	}
+	return nil
+}
+
+// checkObjectSize validates the size of object id reported by its metadata
+func checkObjectSize(id int, size int64) error {
+	if uint64(size) != objectSize(id) {
+		return fmt.Errorf("wrong object size %d, expected %d", size, objectSize(id))
+	}
+	return nil
+}
+
+// receiveObject reads the body of a downloaded object or range and checks it
+func receiveObject(id int, rng byteRange, body io.Reader) error {
+	receiver := newObjectReceiver(id, rng)
+
+	copied, err := io.Copy(receiver, body)
+	if err != nil {
+		return fmt.Errorf("error receiving response %v", err.Error())
+	}
+	return receiver.check(copied)
+}
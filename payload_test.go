@@ -0,0 +1,145 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+// setTestPayload sets the payload of the objects up, as configure does
+func setTestPayload(mode string, seed int64, size uint64) {
+	payloadMode = mode
+	compressionRatio = 1
+	payloadSeed = seed
+	payloadDigests = sync.Map{}
+	setBaseObjectSizes(&sizeDistribution{Kind: sizeFixed, Sizes: []uint64{size}, Weights: []int{1}})
+	initPayload()
+}
+
+func TestVerifyDownloads(t *testing.T) {
+	verifyDownloads = true
+	defer func() { verifyDownloads = false }()
+
+	for _, mode := range []string{payloadRandom, payloadUnique, payloadZeros} {
+		setTestPayload(mode, 1, 64*1024)
+
+		u := NewMemUploader(0, 0, 0)
+		if r := u.DoUpload(context.Background(), 0, newPayload(0)); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+		if r := u.DoDownload(context.Background(), 0, wholeObject(0)); r.Error != nil {
+			t.Errorf("%s: intact object rejected: %v", mode, r.Error)
+		}
+
+		obj, _ := u.Store.get(u.key(0))
+		obj.Data[40000] ^= 1
+
+		if r := u.DoDownload(context.Background(), 0, wholeObject(0)); !isCorrupted(r.Error) {
+			t.Errorf("%s: corrupted object accepted: %v", mode, r.Error)
+		}
+		if r := u.DoDownload(context.Background(), 0, byteRange{Offset: 32768, Length: 16384}); !isCorrupted(r.Error) {
+			t.Errorf("%s: corrupted range accepted: %v", mode, r.Error)
+		}
+		if r := u.DoDownload(context.Background(), 0, byteRange{Offset: 4096, Length: 16384}); r.Error != nil {
+			t.Errorf("%s: intact range rejected: %v", mode, r.Error)
+		}
+	}
+}
+
+func readPayload(t *testing.T, id int) []byte {
+	data, err := ioutil.ReadAll(newPayload(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPayloadModes(t *testing.T) {
+	const size = 100 * 1024
+
+	for _, mode := range []string{payloadRandom, payloadUnique, payloadZeros} {
+		setTestPayload(mode, 1, size)
+		first, second := readPayload(t, 0), readPayload(t, 1)
+		if len(first) != size || len(second) != size {
+			t.Fatalf("%s: objects of %d and %d bytes, expected %d", mode, len(first), len(second), size)
+		}
+
+		zeros := bytes.Equal(first, make([]byte, size))
+		if zeros != (mode == payloadZeros) {
+			t.Errorf("%s: object filled with zeros: %t", mode, zeros)
+		}
+		if same := bytes.Equal(first, second); same != (mode != payloadUnique) {
+			t.Errorf("%s: objects with the same content: %t", mode, same)
+		}
+
+		// The same seed gives the same content, another one other content
+		setTestPayload(mode, 1, size)
+		if !bytes.Equal(readPayload(t, 0), first) {
+			t.Errorf("%s: other content with the same seed", mode)
+		}
+		setTestPayload(mode, 2, size)
+		if same := bytes.Equal(readPayload(t, 0), first); same != (mode == payloadZeros) {
+			t.Errorf("%s: same content with another seed: %t", mode, same)
+		}
+	}
+}
+
+func TestCompressRatio(t *testing.T) {
+	defer func() { compressionRatio = 1 }()
+
+	for _, mode := range []string{payloadRandom, payloadUnique} {
+		for _, ratio := range []float64{1, 2, 4} {
+			setTestPayload(mode, 1, 1024*1024)
+			compressionRatio = ratio
+			initPayload()
+			data := readPayload(t, 0)
+
+			var compressed bytes.Buffer
+			w, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = w.Write(data)
+			_ = w.Close()
+
+			achieved := float64(len(data)) / float64(compressed.Len())
+			if achieved < ratio*0.9 || achieved > ratio*1.1 {
+				t.Errorf("%s: compression ratio %.2f, expected %g", mode, achieved, ratio)
+			}
+		}
+	}
+
+	// Every range of a unique object is compressible like the whole object
+	compressionRatio = 2
+	p := newUniquePayload(0)
+	whole := make([]byte, 3*compressionBlockSize)
+	_, _ = p.ReadAt(whole, 0)
+	part := make([]byte, compressionBlockSize)
+	_, _ = p.ReadAt(part, 1000)
+	if !bytes.Equal(part, whole[1000:1000+compressionBlockSize]) {
+		t.Error("unique payload read at an offset differs from the whole object")
+	}
+}
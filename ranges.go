@@ -0,0 +1,112 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+// Patterns of ranged reads
+const (
+	// successive reads of an object fetch consecutive ranges
+	rangeSequential = "sequential"
+	// every read fetches a range at a random offset
+	rangeRandom = "random"
+)
+
+// Size of the ranges read by the GET and mixed phases, 0 to read whole
+// objects
+var rangeSize uint64
+var rangePattern = rangeSequential
+
+// byteRange is a range of bytes of an object
+type byteRange struct {
+	Offset uint64
+	Length uint64
+	// whole is set when the range covers the whole object, which is then
+	// downloaded without a range request
+	whole bool
+}
+
+func wholeObject(id int) byteRange {
+	return byteRange{Length: objectSize(id), whole: true}
+}
+
+// header returns the value of the HTTP Range header for the range
+func (r byteRange) header() string {
+	return fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+r.Length-1)
+}
+
+// objectRange returns the range of object id fetched by its n-th read
+func objectRange(id int, n int) byteRange {
+	size := objectSize(id)
+	if rangeSize == 0 || rangeSize >= size {
+		return wholeObject(id)
+	}
+
+	var offset uint64
+	if rangePattern == rangeRandom {
+		offset = uint64(rand.Int63n(int64(size - rangeSize + 1)))
+	} else {
+		ranges := (size + rangeSize - 1) / rangeSize
+		offset = (uint64(n) % ranges) * rangeSize
+	}
+
+	length := rangeSize
+	if offset+length > size {
+		length = size - offset
+	}
+	return byteRange{Offset: offset, Length: length}
+}
+
+// rangeWriter writes the part of a stream which falls in a range, skipping
+// the bytes before it and discarding the bytes after it
+type rangeWriter struct {
+	w         io.Writer
+	skip      int64
+	remaining int64
+}
+
+func (r *rangeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if r.skip > 0 {
+		if int64(len(p)) <= r.skip {
+			r.skip -= int64(len(p))
+			return n, nil
+		}
+		p = p[r.skip:]
+		r.skip = 0
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	if len(p) > 0 {
+		if _, err := r.w.Write(p); err != nil {
+			return 0, err
+		}
+		r.remaining -= int64(len(p))
+	}
+	return n, nil
+}
@@ -0,0 +1,306 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// BenchmarkParameters records the options a run was started with
+type BenchmarkParameters struct {
+	Target               string          `json:"target,omitempty"`
+	Endpoint             string          `json:"endpoint"`
+	Protocol             string          `json:"protocol"`
+	HostIP               string          `json:"host_ip"`
+	Bucket               string          `json:"bucket"`
+	Region               string          `json:"region,omitempty"`
+	Duration             int             `json:"duration_secs"`
+	Threads              int             `json:"threads"`
+	ObjectSize           uint64          `json:"object_size"`
+	Sizes                string          `json:"sizes"`
+	Loops                int             `json:"loops"`
+	Multipart            bool            `json:"multipart"`
+	PartSize             uint64          `json:"part_size,omitempty"`
+	MultipartConcurrency int             `json:"multipart_concurrency,omitempty"`
+	MaxRetries           int             `json:"max_retries"`
+	Prefix               string          `json:"prefix"`
+	Payload              string          `json:"payload"`
+	Seed                 int64           `json:"seed"`
+	Compression          float64         `json:"compress_ratio"`
+	Mix                  string          `json:"mix,omitempty"`
+	Verify               bool            `json:"verify"`
+	Head                 bool            `json:"head"`
+	Copy                 bool            `json:"copy"`
+	DeleteBatch          int             `json:"delete_batch"`
+	Interval             float64         `json:"interval_secs,omitempty"`
+	Warmup               float64         `json:"warmup_secs,omitempty"`
+	Rate                 float64         `json:"rate,omitempty"`      // requests per second
+	Bandwidth            uint64          `json:"bandwidth,omitempty"` // bytes per second
+	ThreadSweep          string          `json:"thread_sweep,omitempty"`
+	SizeSweep            string          `json:"size_sweep,omitempty"`
+	Workers              []string        `json:"workers,omitempty"`
+	Workload             []workloadPhase `json:"workload,omitempty"`
+	RangeSize            uint64          `json:"range_size,omitempty"`
+	RangePattern         string          `json:"range_pattern,omitempty"`
+	ListObjects          int             `json:"list_objects,omitempty"`
+	ListPageSize         int             `json:"list_page_size,omitempty"`
+}
+
+// PhaseResult holds the statistics of a single phase (PUT, GET, HEAD, LIST,
+// COPY, DELETE) of a loop, or of an operation of the mixed workload
+type PhaseResult struct {
+	Operation  string            `json:"operation"`
+	Threads    int               `json:"threads"`
+	ObjectSize uint64            `json:"object_size"`
+	Time       float64           `json:"time_secs"`
+	Successful int               `json:"successful"`
+	Failed     int               `json:"failed"`
+	Corrupted  int               `json:"corrupted"`
+	Bytes      uint64            `json:"bytes"`
+	MBps       float64           `json:"mbps"`
+	OpsPerSec  float64           `json:"ops_per_sec"`
+	Latency    LatencyStats      `json:"latency"`
+	Histogram  []HistogramBucket `json:"histogram,omitempty"`
+
+	// Only set for listings
+	Objects       uint64  `json:"objects,omitempty"`
+	ObjectsPerSec float64 `json:"objects_per_sec,omitempty"`
+
+	// Only set when the objects have different sizes
+	SizeBuckets []SizeBucketResult `json:"size_buckets,omitempty"`
+
+	// Only set when the phase is split in intervals
+	Intervals []IntervalResult `json:"intervals,omitempty"`
+}
+
+type LoopResult struct {
+	Loop   int           `json:"loop"`
+	Phases []PhaseResult `json:"phases"`
+}
+
+type BenchmarkReport struct {
+	Version    string              `json:"version"`
+	Date       time.Time           `json:"date"`
+	Parameters BenchmarkParameters `json:"parameters"`
+	Loops      []LoopResult        `json:"loops"`
+	Sweep      []SweepResult       `json:"sweep,omitempty"`
+}
+
+// phaseRun holds the results of the requests of a phase, before they are
+// aggregated in a PhaseResult
+type phaseRun struct {
+	Operation  string
+	Threads    int
+	ObjectSize uint64
+	Time       float64
+	Results    []TransferResult
+	Intervals  []IntervalResult
+}
+
+func (p phaseRun) phaseResult() PhaseResult {
+	phase := newPhaseResult(p.Operation, p.Time, p.Results)
+	// The phase may have run with other settings than the current ones
+	phase.Threads = p.Threads
+	phase.ObjectSize = p.ObjectSize
+	phase.Intervals = p.Intervals
+	return phase
+}
+
+// newPhaseResult aggregates the results of the requests issued during a phase
+// lasting elapsed seconds
+func newPhaseResult(operation string, elapsed float64, results []TransferResult) PhaseResult {
+	phase := PhaseResult{
+		Operation:  operation,
+		Threads:    threads,
+		ObjectSize: phaseObjectSize(),
+		Time:       elapsed,
+	}
+
+	durations := make([]float64, 0, len(results))
+	for _, r := range results {
+		if r.Error != nil {
+			phase.Failed++
+			if isCorrupted(r.Error) {
+				phase.Corrupted++
+			}
+			continue
+		}
+		phase.Successful++
+		phase.Bytes += r.Bytes
+		phase.Objects += uint64(r.Objects)
+		durations = append(durations, r.Duration.Seconds())
+	}
+	sort.Float64s(durations)
+
+	if elapsed > 0 {
+		phase.MBps = (float64(phase.Bytes) / elapsed) / (1000 * 1000)
+		phase.OpsPerSec = float64(phase.Successful) / elapsed
+		phase.ObjectsPerSec = float64(phase.Objects) / elapsed
+	}
+	phase.Latency = computeLatencyStats(durations)
+	phase.Histogram = computeHistogram(durations)
+
+	if !objectSizes.fixed() {
+		phase.SizeBuckets = newSizeBuckets(elapsed, results)
+	}
+
+	return phase
+}
+
+// phaseObjectSize returns the size of the objects of a phase, or 0 when
+// they have different sizes
+func phaseObjectSize() uint64 {
+	if objectSizes.fixed() {
+		return object_size
+	}
+	return 0
+}
+
+// The Corrupted column, a subset of Failed, is only shown when verifying
+func printPhaseHeader() {
+	fmt.Printf("%-9s%-6s%-11s%-7s%-12s%-8s", "Threads", "Size", "Operation", "Time", "Successful", "Failed")
+	if verifyDownloads {
+		fmt.Printf("%-11s", "Corrupted")
+	}
+	fmt.Printf("%-9s%-9s\n", "MBps", "Ops/s")
+}
+
+func printPhaseResult(p PhaseResult) {
+	size := "var"
+	if p.ObjectSize > 0 {
+		size = bytefmt.ByteSize(p.ObjectSize)
+	}
+
+	fmt.Printf("%-9d%-6v%-11s%-7.2f%-12v%-8v",
+		p.Threads, size, p.Operation, p.Time, p.Successful, p.Failed)
+	if verifyDownloads {
+		fmt.Printf("%-11v", p.Corrupted)
+	}
+	fmt.Printf("%-9.2f%-9.0f", p.MBps, p.OpsPerSec)
+	if p.Objects > 0 {
+		fmt.Printf("(%.0f objects/s)", p.ObjectsPerSec)
+	}
+	fmt.Println("")
+}
+
+// reportFormat returns the format to write the report in, guessing it from
+// the file extension when not explicitly given
+func reportFormat(path, format string) (string, error) {
+	if format == "" {
+		if strings.ToLower(filepath.Ext(path)) == ".csv" {
+			return "csv", nil
+		}
+		return "json", nil
+	}
+
+	switch format {
+	case "json", "csv":
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q: available: json, csv", format)
+	}
+}
+
+// writeReport (re)writes the whole report to path, so that the file is
+// usable even if the benchmark is interrupted
+func writeReport(path, format string, report *BenchmarkReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "csv":
+		err = writeReportCSV(f, report)
+	default:
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	}
+
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeReportCSV writes one row per phase, each carrying the run parameters
+// and the latency histogram, one hist_le_BOUND column per bucket
+func writeReportCSV(f io.Writer, report *BenchmarkReport) error {
+	w := csv.NewWriter(f)
+
+	header := []string{
+		"date", "target", "endpoint", "protocol", "bucket", "multipart", "loop",
+		"operation", "threads", "object_size", "time_secs", "successful", "failed", "corrupted",
+		"bytes", "mbps", "ops_per_sec", "objects", "objects_per_sec", "lat_min", "lat_avg", "lat_p50",
+		"lat_p90", "lat_p99", "lat_p999", "lat_max",
+	}
+	for _, bound := range histogramBounds {
+		header = append(header, "hist_le_"+strconv.FormatFloat(bound, 'g', -1, 64))
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	p := report.Parameters
+	ff := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+	for _, loop := range report.Loops {
+		for _, phase := range loop.Phases {
+			l := phase.Latency
+			row := []string{
+				report.Date.Format(time.RFC3339), p.Target, p.Endpoint, p.Protocol, p.Bucket,
+				strconv.FormatBool(p.Multipart), strconv.Itoa(loop.Loop),
+				phase.Operation, strconv.Itoa(phase.Threads),
+				strconv.FormatUint(phase.ObjectSize, 10), ff(phase.Time),
+				strconv.Itoa(phase.Successful), strconv.Itoa(phase.Failed), strconv.Itoa(phase.Corrupted),
+				strconv.FormatUint(phase.Bytes, 10), ff(phase.MBps), ff(phase.OpsPerSec),
+				strconv.FormatUint(phase.Objects, 10), ff(phase.ObjectsPerSec),
+				ff(l.Min), ff(l.Avg), ff(l.P50), ff(l.P90), ff(l.P99), ff(l.P999), ff(l.Max),
+			}
+			for i := range histogramBounds {
+				count := 0
+				if i < len(phase.Histogram) {
+					count = phase.Histogram[i].Count
+				}
+				row = append(row, strconv.Itoa(count))
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
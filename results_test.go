@@ -0,0 +1,78 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestWriteReportCSV(t *testing.T) {
+	report := &BenchmarkReport{
+		Parameters: BenchmarkParameters{Target: "aws", Protocol: "s3v4"},
+		Loops: []LoopResult{{Loop: 1, Phases: []PhaseResult{{
+			Operation:  "GET",
+			Threads:    8,
+			ObjectSize: 1024,
+			Successful: 3,
+			Histogram:  computeHistogram([]float64{0.0005, 0.003, 0.004}),
+		}}}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeReportCSV(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(buf.Bytes())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("%d rows, expected a header and a phase", len(rows))
+	}
+
+	row := make(map[string]string)
+	for i, name := range rows[0] {
+		row[name] = rows[1][i]
+	}
+	expected := map[string]string{
+		"target":        "aws",
+		"hist_le_0.001": "1",
+		"hist_le_0.002": "0",
+		"hist_le_0.005": "2",
+		"hist_le_+Inf":  "0",
+	}
+	for name, value := range expected {
+		if row[name] != value {
+			t.Errorf("column %s: %q, expected %q", name, row[name], value)
+		}
+	}
+
+	// compare reads the rows back
+	var read BenchmarkReport
+	if err := readReportCSV(csv.NewReader(&buf), &read); err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Loops) != 1 || read.Loops[0].Phases[0].Threads != 8 {
+		t.Errorf("read back %+v", read.Loops)
+	}
+}
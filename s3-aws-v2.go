@@ -24,13 +24,16 @@ import (
 	"bytes"
 	"context"
 	"crypto/hmac"
+	"crypto/md5"
 	"crypto/sha1"
 	"encoding/base64"
+	"encoding/xml"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -57,6 +60,48 @@ func (u *S3AwsV2) Prepare(bucket string) error {
 	return nil
 }
 
+func (u *S3AwsV2) DoCopy(ctx context.Context, srcID, dstID int) (result TransferResult) {
+	srcKey := fmt.Sprintf("%s-%d", objPrefix, srcID)
+	dstKey := fmt.Sprintf("%s-%d", objPrefix, dstID)
+	path := fmt.Sprintf("%s/%s/%s", u.Host, u.Bucket, dstKey)
+
+	req, _ := http.NewRequest("PUT", path, nil)
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Amz-Copy-Source", "/"+url.PathEscape(u.Bucket)+"/"+url.PathEscape(srcKey))
+	setSignature(req, u.AccessKey, u.SecretKey)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		result.Error = fmt.Errorf("error copying object %s to %s: %v", srcKey, dstKey, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		result.Error = fmt.Errorf("error receiving response %v", err.Error())
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			result.Error = fmt.Errorf("slowdown requested")
+		} else {
+			result.Error = fmt.Errorf("not-ok status, received %d, %s, %s",
+				resp.StatusCode, resp.Status, string(body))
+		}
+		return
+	}
+
+	// A copy can fail after the 200 status has been sent, the error is then
+	// in the body
+	var copyResult s3CopyObjectResult
+	if err := xml.Unmarshal(body, &copyResult); err != nil {
+		result.Error = fmt.Errorf("error copying object %s to %s: %s", srcKey, dstKey, string(body))
+	}
+	return
+}
+
 func (u *S3AwsV2) DoDelete(ctx context.Context, id int) error {
 	key := fmt.Sprintf("%s-%d", objPrefix, id)
 	path := fmt.Sprintf("%s/%s/%s", u.Host, u.Bucket, key)
@@ -76,12 +121,88 @@ func (u *S3AwsV2) DoDelete(ctx context.Context, id int) error {
 	return err
 }
 
-func (u *S3AwsV2) DoDownload(ctx context.Context, id int) (result TransferResult) {
+func (u *S3AwsV2) DoBatchDelete(ctx context.Context, ids []int) (results []TransferResult) {
+	request := s3Delete{Xmlns: s3XMLNamespace, Quiet: true}
+	keys := make(map[string]int, len(ids))
+	for _, id := range ids {
+		key := fmt.Sprintf("%s-%d", objPrefix, id)
+		keys[key] = id
+		request.Objects = append(request.Objects, struct{ Key string }{key})
+	}
+
+	failed := make(map[int]error)
+	err := u.deleteObjects(ctx, &request, keys, failed)
+	if err != nil {
+		log.Error(err)
+	}
+
+	for _, id := range ids {
+		r := TransferResult{Id: id, Error: err}
+		if e, ok := failed[id]; ok {
+			r.Error = e
+		}
+		results = append(results, r)
+	}
+	return
+}
+
+// deleteObjects sends a DeleteObjects request, filling failed with the
+// errors of the objects which could not be deleted
+func (u *S3AwsV2) deleteObjects(ctx context.Context, request *s3Delete, keys map[string]int, failed map[int]error) error {
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(body)
+
+	path := fmt.Sprintf("%s/%s?delete", u.Host, u.Bucket)
+	req, _ := http.NewRequest("POST", path, bytes.NewReader(body))
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	req.Header.Set("Content-Type", "application/xml")
+	setSignature(req, u.AccessKey, u.SecretKey)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error deleting %d objects: %v", len(keys), err)
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("error receiving response %v", err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			return fmt.Errorf("slowdown requested")
+		}
+		return fmt.Errorf("not-ok status, received %d, %s, %s",
+			resp.StatusCode, resp.Status, string(respBody))
+	}
+
+	var result s3DeleteResult
+	if err := xml.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("error parsing response of delete: %v", err)
+	}
+	for _, e := range result.Errors {
+		failed[keys[e.Key]] = fmt.Errorf("error deleting object %s: %s", e.Key, e.Message)
+	}
+	return nil
+}
+
+func (u *S3AwsV2) DoDownload(ctx context.Context, id int, rng byteRange) (result TransferResult) {
 	key := fmt.Sprintf("%s-%d", objPrefix, id)
 	path := fmt.Sprintf("%s/%s/%s", u.Host, u.Bucket, key)
 
 	req, _ := http.NewRequest("GET", path, nil)
 	req = req.WithContext(ctx)
+
+	expectedStatus := http.StatusOK
+	if !rng.whole {
+		req.Header.Set("Range", rng.header())
+		expectedStatus = http.StatusPartialContent
+	}
 	setSignature(req, u.AccessKey, u.SecretKey)
 
 	resp, err := httpClient.Do(req)
@@ -90,7 +211,7 @@ func (u *S3AwsV2) DoDownload(ctx context.Context, id int) (result TransferResult
 		return
 	}
 
-	if resp.StatusCode != 200 {
+	if resp.StatusCode != expectedStatus {
 		if resp.StatusCode == http.StatusServiceUnavailable {
 			result.Error = fmt.Errorf("slowdown requested")
 		} else {
@@ -111,32 +232,99 @@ func (u *S3AwsV2) DoDownload(ctx context.Context, id int) (result TransferResult
 	}
 
 	// Receive response
-	copied, err := io.Copy(ioutil.Discard, resp.Body)
+	result.Error = receiveObject(id, rng, resp.Body)
+	_ = resp.Body.Close()
+
+	return
+}
+
+func (u *S3AwsV2) DoHead(ctx context.Context, id int) (result TransferResult) {
+	key := fmt.Sprintf("%s-%d", objPrefix, id)
+	path := fmt.Sprintf("%s/%s/%s", u.Host, u.Bucket, key)
+
+	req, _ := http.NewRequest("HEAD", path, nil)
+	req = req.WithContext(ctx)
+	setSignature(req, u.AccessKey, u.SecretKey)
+
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		result.Error = fmt.Errorf("error receiving response %v", err.Error())
+		result.Error = fmt.Errorf("error reading metadata of object %s: %v", path, err)
 		return
 	}
-
 	_ = resp.Body.Close()
 
-	if uint64(copied) != object_size {
-		result.Error = fmt.Errorf("wrong response size")
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			result.Error = fmt.Errorf("slowdown requested")
+		} else {
+			result.Error = fmt.Errorf("non-ok status %d, %v", resp.StatusCode, resp.Status)
+		}
 		return
 	}
 
+	result.Error = checkObjectSize(id, resp.ContentLength)
 	return
 }
 
-func (u *S3AwsV2) DoUpload(ctx context.Context, id int, data io.ReadSeeker) (result TransferResult) {
-	fileobj := bytes.NewReader(object_data)
+func (u *S3AwsV2) DoList(ctx context.Context, prefix string) (result TransferResult) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	query.Set("max-keys", strconv.Itoa(listPageSize))
+
+	for {
+		path := fmt.Sprintf("%s/%s?%s", u.Host, u.Bucket, query.Encode())
+
+		req, _ := http.NewRequest("GET", path, nil)
+		req = req.WithContext(ctx)
+		setSignature(req, u.AccessKey, u.SecretKey)
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			result.Error = fmt.Errorf("error listing objects %s: %v", path, err)
+			return
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			result.Error = fmt.Errorf("error receiving response %v", err.Error())
+			return
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			if resp.StatusCode == http.StatusServiceUnavailable {
+				result.Error = fmt.Errorf("slowdown requested")
+			} else {
+				result.Error = fmt.Errorf("not-ok status, received %d, %s, %s",
+					resp.StatusCode, resp.Status, string(body))
+			}
+			return
+		}
+
+		var page s3ListBucketResult
+		if err := xml.Unmarshal(body, &page); err != nil {
+			result.Error = fmt.Errorf("error parsing listing of %s: %v", path, err)
+			return
+		}
+
+		result.Objects += len(page.Contents)
+		if !page.IsTruncated {
+			return
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
 
+func (u *S3AwsV2) DoUpload(ctx context.Context, id int, data io.ReadSeeker) (result TransferResult) {
 	key := fmt.Sprintf("%s-%d", objPrefix, id)
 	path := fmt.Sprintf("%s/%s/%s", u.Host, u.Bucket, key)
 
 	result.Id = id
-	req, _ := http.NewRequest("PUT", path, fileobj)
+	req, _ := http.NewRequest("PUT", path, data)
 	req = req.WithContext(ctx)
-	req.Header.Set("Content-Length", strconv.FormatUint(object_size, 10))
+	req.ContentLength = int64(objectSize(id))
+	req.Header.Set("Content-Length", strconv.FormatUint(objectSize(id), 10))
 
 	// req.Header.Set("Content-MD5", object_data_md5)
 	setSignature(req, u.AccessKey, u.SecretKey)
@@ -164,6 +352,39 @@ func (u *S3AwsV2) DoUpload(ctx context.Context, id int, data io.ReadSeeker) (res
 }
 
 
+// Query parameters that are part of the resource signed with SigV2
+var s3V2SubResources = map[string]bool{
+	"acl": true, "delete": true, "lifecycle": true, "location": true,
+	"logging": true, "notification": true, "partNumber": true, "policy": true,
+	"requestPayment": true, "tagging": true, "torrent": true, "uploadId": true,
+	"uploads": true, "versionId": true, "versioning": true, "versions": true, "website": true,
+	"response-cache-control": true, "response-content-disposition": true,
+	"response-content-encoding": true, "response-content-language": true,
+	"response-content-type": true, "response-expires": true,
+}
+
+// v2SubResources returns the subresources of the query, which are part of the
+// signed resource
+func v2SubResources(query url.Values) string {
+	var names []string
+	for name := range query {
+		if s3V2SubResources[name] {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+
+	sort.Strings(names)
+	for n, name := range names {
+		if value := query.Get(name); value != "" {
+			names[n] = name + "=" + value
+		}
+	}
+	return "?" + strings.Join(names, "&")
+}
+
 func parseAmzHeaders(req *http.Request) string {
 	var headers []string
 	for header := range req.Header {
@@ -193,7 +414,7 @@ func hmacSHA1(key []byte, content string) []byte {
 func setSignature(req *http.Request, accessKey, secretKey string) {
 	dateHdr := time.Now().UTC().Format("20060102T150405Z")
 	req.Header.Set("X-Amz-Date", dateHdr)
-	parsedResource := req.URL.EscapedPath()
+	parsedResource := req.URL.EscapedPath() + v2SubResources(req.URL.Query())
 	parsedHeaders := parseAmzHeaders(req)
 	stringToSign := req.Method + "\n" + req.Header.Get("Content-MD5") + "\n" + req.Header.Get("Content-Type") + "\n\n" +
 		parsedHeaders + parsedResource
@@ -21,6 +21,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
@@ -31,6 +32,8 @@ import (
 	log "github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
+	"net/url"
+	"sync"
 )
 
 var discarder = &DiscardWriterAt{}
@@ -86,9 +89,104 @@ func (u *S3AwsV4) Prepare(bucket string) error {
 		Bucket: &bucket,
 	})
 	if err != nil {
-		log.Fatal("unable to access the bucket ", err)
+		return fmt.Errorf("unable to access the bucket: %v", err)
 	}
-	return err
+	return nil
+}
+
+func (u *S3AwsV4) DoCopy(ctx context.Context, srcID, dstID int) (result TransferResult) {
+	srcKey := fmt.Sprintf("%s-%d", objPrefix, srcID)
+	dstKey := fmt.Sprintf("%s-%d", objPrefix, dstID)
+	copySource := url.PathEscape(u.Bucket) + "/" + url.PathEscape(srcKey)
+
+	var err error
+	// An empty object has no range to copy with UploadPartCopy
+	if u.UseMultipart && objectSize(srcID) > 0 {
+		err = u.copyMultipart(ctx, copySource, dstKey, objectSize(srcID))
+	} else {
+		_, err = u.S3.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     &u.Bucket,
+			Key:        &dstKey,
+			CopySource: &copySource,
+		})
+	}
+
+	if err != nil {
+		result.Error = fmt.Errorf("error copying object %s to %s: %v", srcKey, dstKey, err)
+	}
+	return
+}
+
+// copyMultipart copies a non-empty object with UploadPartCopy, sending up to
+// multipartConcurrency parts in parallel
+func (u *S3AwsV4) copyMultipart(ctx context.Context, copySource, key string, size uint64) error {
+	upload, err := u.S3.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: &u.Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return err
+	}
+
+	var parts []*s3.CompletedPart
+	for offset := uint64(0); offset < size; offset += part_size {
+		parts = append(parts, &s3.CompletedPart{PartNumber: aws.Int64(int64(len(parts) + 1))})
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, multipartConcurrency)
+	wg := sync.WaitGroup{}
+	for i, part := range parts {
+		start := uint64(i) * part_size
+		end := start + part_size - 1
+		if end >= size {
+			end = size - 1
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(part *s3.CompletedPart, copyRange string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			partRes, err := u.S3.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+				Bucket:          &u.Bucket,
+				Key:             &key,
+				CopySource:      &copySource,
+				CopySourceRange: aws.String(copyRange),
+				PartNumber:      part.PartNumber,
+				UploadId:        upload.UploadId,
+			})
+
+			mu.Lock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			} else if err == nil {
+				part.ETag = partRes.CopyPartResult.ETag
+			}
+			mu.Unlock()
+		}(part, fmt.Sprintf("bytes=%d-%d", start, end))
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		_, firstErr = u.S3.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          &u.Bucket,
+			Key:             &key,
+			UploadId:        upload.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+
+	if firstErr != nil {
+		_, _ = u.S3.AbortMultipartUploadWithContext(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   &u.Bucket,
+			Key:      &key,
+			UploadId: upload.UploadId,
+		})
+	}
+	return firstErr
 }
 
 func (u *S3AwsV4) DoDelete(ctx context.Context, id int) error {
@@ -104,10 +202,50 @@ func (u *S3AwsV4) DoDelete(ctx context.Context, id int) error {
 	return err
 }
 
-func (u *S3AwsV4) DoDownload(ctx context.Context, id int) (result TransferResult) {
+func (u *S3AwsV4) DoBatchDelete(ctx context.Context, ids []int) (results []TransferResult) {
+	keys := make(map[string]int, len(ids))
+	objects := make([]*s3.ObjectIdentifier, 0, len(ids))
+	for _, id := range ids {
+		key := fmt.Sprintf("%s-%d", objPrefix, id)
+		keys[key] = id
+		objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+	}
+
+	deleteRes, err := u.S3.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: &u.Bucket,
+		Delete: &s3.Delete{
+			Objects: objects,
+			Quiet:   aws.Bool(true),
+		},
+	})
+	if err != nil {
+		err = fmt.Errorf("error deleting %d objects: %v", len(ids), err)
+		log.Error(err)
+	}
+
+	// In quiet mode only the objects which could not be deleted are listed
+	failed := make(map[int]error)
+	if err == nil {
+		for _, e := range deleteRes.Errors {
+			failed[keys[aws.StringValue(e.Key)]] = fmt.Errorf("error deleting object %s: %s",
+				aws.StringValue(e.Key), aws.StringValue(e.Message))
+		}
+	}
+
+	for _, id := range ids {
+		r := TransferResult{Id: id, Error: err}
+		if e, ok := failed[id]; ok {
+			r.Error = e
+		}
+		results = append(results, r)
+	}
+	return
+}
+
+func (u *S3AwsV4) DoDownload(ctx context.Context, id int, rng byteRange) (result TransferResult) {
 	var err error
 	var getObjRes *s3.GetObjectOutput
-	var copied int64
+	var downloaded int64
 
 	key := fmt.Sprintf("%s-%d", objPrefix, id)
 
@@ -116,8 +254,22 @@ func (u *S3AwsV4) DoDownload(ctx context.Context, id int) (result TransferResult
 		Key:    &key,
 	}
 
-	if u.UseMultipart {
-		_, err = u.MPDownloader.DownloadWithContext(ctx, discarder, &getObjInput)
+	// Ranges are fetched with a single request
+	useMultipart := u.UseMultipart && rng.whole
+	if !rng.whole {
+		getObjInput.Range = aws.String(rng.header())
+	}
+
+	// The multipart downloader writes the parts out of order, so the
+	// object must be buffered to be verified
+	var buffer *aws.WriteAtBuffer
+	if useMultipart {
+		var w io.WriterAt = discarder
+		if verifyDownloads {
+			buffer = aws.NewWriteAtBuffer(make([]byte, 0, objectSize(id)))
+			w = buffer
+		}
+		downloaded, err = u.MPDownloader.DownloadWithContext(ctx, w, &getObjInput)
 	} else {
 		getObjRes, err = u.S3.GetObjectWithContext(ctx, &getObjInput)
 	}
@@ -127,22 +279,54 @@ func (u *S3AwsV4) DoDownload(ctx context.Context, id int) (result TransferResult
 		return
 	}
 
-	if !u.UseMultipart {
+	if !useMultipart {
 		// manually receive the file
-		copied, err = io.Copy(ioutil.Discard, getObjRes.Body)
+		result.Error = receiveObject(id, rng, getObjRes.Body)
+		_ = getObjRes.Body.Close()
+		return
+	}
 
-		// set the duration again
-		if err != nil {
-			result.Error = fmt.Errorf("error receiving response %v", err.Error())
-			return
-		}
+	if buffer != nil {
+		result.Error = receiveObject(id, rng, bytes.NewReader(buffer.Bytes()))
+	} else if uint64(downloaded) != objectSize(id) {
+		result.Error = fmt.Errorf("wrong response size")
+	}
 
-		if uint64(copied) != object_size {
-			result.Error = fmt.Errorf("wrong response size")
-			return
-		}
+	return
+}
+
+func (u *S3AwsV4) DoHead(ctx context.Context, id int) (result TransferResult) {
+	key := fmt.Sprintf("%s-%d", objPrefix, id)
+
+	headObjRes, err := u.S3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: &u.Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		result.Error = fmt.Errorf("error reading metadata of object %s: %v", key, err)
+		return
 	}
 
+	result.Error = checkObjectSize(id, aws.Int64Value(headObjRes.ContentLength))
+	return
+}
+
+func (u *S3AwsV4) DoList(ctx context.Context, prefix string) (result TransferResult) {
+	listInput := s3.ListObjectsV2Input{
+		Bucket:  &u.Bucket,
+		Prefix:  &prefix,
+		MaxKeys: aws.Int64(int64(listPageSize)),
+	}
+
+	err := u.S3.ListObjectsV2PagesWithContext(ctx, &listInput,
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			result.Objects += len(page.Contents)
+			return true
+		})
+
+	if err != nil {
+		result.Error = fmt.Errorf("error listing objects %s: %v", prefix, err)
+	}
 	return
 }
 
@@ -0,0 +1,1077 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const s3XMLNamespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// Requests whose date differs from the server clock by more than this are
+// rejected, as AWS does
+const s3MaxClockSkew = 15 * time.Minute
+
+type s3Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errS3AccessDenied          = &s3Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errS3InvalidAccessKeyID    = &s3Error{http.StatusForbidden, "InvalidAccessKeyId", "The access key you provided does not exist"}
+	errS3SignatureMismatch     = &s3Error{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided"}
+	errS3TimeTooSkewed         = &s3Error{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large"}
+	errS3ContentSHA256Mismatch = &s3Error{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed"}
+	errS3BadDigest             = &s3Error{http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received"}
+	errS3InvalidDigest         = &s3Error{http.StatusBadRequest, "InvalidDigest", "The Content-MD5 you specified is not valid"}
+	errS3MalformedXML          = &s3Error{http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed"}
+	errS3InvalidPart           = &s3Error{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found"}
+	errS3InvalidPartOrder      = &s3Error{http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order"}
+	errS3InvalidArgument       = &s3Error{http.StatusBadRequest, "InvalidArgument", "Invalid argument"}
+	errS3InvalidRange          = &s3Error{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable"}
+	errS3NoSuchBucket          = &s3Error{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"}
+	errS3NoSuchKey             = &s3Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist"}
+	errS3NoSuchUpload          = &s3Error{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist"}
+	errS3BucketNotEmpty        = &s3Error{http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty"}
+	errS3MethodNotAllowed      = &s3Error{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource"}
+	errS3NotImplemented        = &s3Error{http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented"}
+)
+
+type s3ErrorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Resource  string
+	RequestId string
+}
+
+type s3InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+type s3CompleteMultipartUpload struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type s3CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+type s3CopyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	LastModified string
+	ETag         string
+}
+
+type s3CopyPartResult struct {
+	XMLName      xml.Name `xml:"CopyPartResult"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	LastModified string
+	ETag         string
+}
+
+type s3Delete struct {
+	XMLName xml.Name `xml:"Delete"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Quiet   bool     `xml:",omitempty"`
+	Objects []struct {
+		Key string
+	} `xml:"Object"`
+}
+
+type s3DeleteResult struct {
+	XMLName xml.Name `xml:"DeleteResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Deleted []struct {
+		Key string
+	}
+	Errors []struct {
+		Key     string
+		Code    string
+		Message string
+	} `xml:"Error"`
+}
+
+// Maximum number of objects deleted by a DeleteObjects request
+const s3MaxDeleteObjects = 1000
+
+type s3ListBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Xmlns                 string   `xml:"xmlns,attr"`
+	Name                  string
+	Prefix                string
+	KeyCount              int
+	MaxKeys               int
+	IsTruncated           bool
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	Contents              []s3ListObject
+}
+
+type s3ListObject struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+// Maximum number of keys returned by a listing, as on AWS
+const s3MaxKeys = 1000
+
+// Format of the dates in XML responses
+const s3TimeFormat = "2006-01-02T15:04:05.000Z"
+
+type s3MultipartUpload struct {
+	Bucket string
+	Key    string
+	mu     sync.Mutex
+	parts  map[int]*memObject
+}
+
+// S3Server is a minimal S3 compatible server keeping objects in memory. It
+// supports path-style requests signed with SigV2 or SigV4 (header based,
+// not presigned), and is meant to test the s3v2 and s3v4 clients locally.
+type S3Server struct {
+	AccessKey string
+	SecretKey string
+	Region    string
+	Verbose   bool
+
+	store *memStore
+
+	mu        sync.Mutex
+	buckets   map[string]bool
+	uploads   map[string]*s3MultipartUpload
+	requestID uint64
+}
+
+func NewS3Server(accessKey, secretKey, region string) *S3Server {
+	return &S3Server{
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Region:    region,
+		store:     newMemStore(),
+		buckets:   make(map[string]bool),
+		uploads:   make(map[string]*s3MultipartUpload),
+	}
+}
+
+func (s *S3Server) CreateBucket(bucket string) {
+	s.mu.Lock()
+	s.buckets[bucket] = true
+	s.mu.Unlock()
+}
+
+func (s *S3Server) bucketExists(bucket string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buckets[bucket]
+}
+
+// serveS3 implements the serve-s3 subcommand
+func serveS3(args []string) {
+	var listen, accessKey, secretKey, region, buckets string
+	var verbose bool
+
+	myflag := flag.NewFlagSet("serve-s3", flag.ExitOnError)
+	myflag.StringVar(&listen, "listen", "127.0.0.1:9000", "address to listen on")
+	myflag.StringVar(&accessKey, "a", "", "Access key accepted by the server, requests are not authenticated if empty")
+	myflag.StringVar(&secretKey, "s", "", "Secret key accepted by the server")
+	myflag.StringVar(&region, "r", "", "Region expected in SigV4 signatures, any region is accepted if empty")
+	myflag.StringVar(&buckets, "b", "", "comma separated list of buckets to create at startup")
+	myflag.BoolVar(&verbose, "v", false, "log every request")
+
+	if err := myflag.Parse(args); err != nil {
+		fmt.Println("Unable to parse flags")
+		printHelp()
+	}
+
+	if accessKey != "" && secretKey == "" {
+		fmt.Println("Missing argument -s for secret key.")
+		printHelp()
+	}
+
+	server := NewS3Server(accessKey, secretKey, region)
+	server.Verbose = verbose
+	for _, bucket := range strings.Split(buckets, ",") {
+		if bucket != "" {
+			server.CreateBucket(bucket)
+		}
+	}
+
+	log.Infof("S3 server listening on %s", listen)
+	if err := http.ListenAndServe(listen, server); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(0)
+}
+
+func (s *S3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestID := strconv.FormatUint(atomic.AddUint64(&s.requestID, 1), 16)
+	w.Header().Set("x-amz-request-id", requestID)
+
+	if s.Verbose {
+		log.Infof("%s %s", r.Method, r.URL.RequestURI())
+	}
+
+	err := s.handle(w, r)
+	if err == nil {
+		return
+	}
+
+	s3err, ok := err.(*s3Error)
+	if !ok {
+		s3err = &s3Error{http.StatusInternalServerError, "InternalError", err.Error()}
+	}
+
+	if s.Verbose {
+		log.Errorf("%s %s: %v", r.Method, r.URL.RequestURI(), s3err)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(s3err.Status)
+	if r.Method != http.MethodHead {
+		_ = xml.NewEncoder(w).Encode(s3ErrorResponse{
+			Code:      s3err.Code,
+			Message:   s3err.Message,
+			Resource:  r.URL.Path,
+			RequestId: requestID,
+		})
+	}
+}
+
+func (s *S3Server) handle(w http.ResponseWriter, r *http.Request) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.authenticate(r, body); err != nil {
+		return err
+	}
+
+	if md5Header := r.Header.Get("Content-MD5"); md5Header != "" {
+		expected, err := base64.StdEncoding.DecodeString(md5Header)
+		if err != nil || len(expected) != md5.Size {
+			return errS3InvalidDigest
+		}
+		sum := md5.Sum(body)
+		if !bytes.Equal(expected, sum[:]) {
+			return errS3BadDigest
+		}
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == "" {
+		return errS3NotImplemented
+	}
+
+	parts := strings.SplitN(path, "/", 2)
+	bucket := parts[0]
+	if len(parts) == 1 || parts[1] == "" {
+		return s.handleBucket(w, r, bucket, body)
+	}
+
+	if !s.bucketExists(bucket) {
+		return errS3NoSuchBucket
+	}
+	return s.handleObject(w, r, bucket, parts[1], body)
+}
+
+func (s *S3Server) handleBucket(w http.ResponseWriter, r *http.Request, bucket string, body []byte) error {
+	switch r.Method {
+	case http.MethodPut:
+		s.CreateBucket(bucket)
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodHead:
+		if !s.bucketExists(bucket) {
+			return errS3NoSuchBucket
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodDelete:
+		if !s.bucketExists(bucket) {
+			return errS3NoSuchBucket
+		}
+		if s.store.count(bucket+"/") > 0 {
+			return errS3BucketNotEmpty
+		}
+		s.mu.Lock()
+		delete(s.buckets, bucket)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case http.MethodGet:
+		if !s.bucketExists(bucket) {
+			return errS3NoSuchBucket
+		}
+		return s.listObjectsV2(w, r, bucket)
+	case http.MethodPost:
+		if _, ok := r.URL.Query()["delete"]; !ok {
+			return errS3NotImplemented
+		}
+		if !s.bucketExists(bucket) {
+			return errS3NoSuchBucket
+		}
+		return s.deleteObjects(w, r, bucket, body)
+	default:
+		return errS3MethodNotAllowed
+	}
+}
+
+// deleteObjects answers a DeleteObjects request. Deleting a missing object
+// succeeds, as on AWS.
+func (s *S3Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string, body []byte) error {
+	if r.Header.Get("Content-MD5") == "" {
+		return errS3InvalidDigest
+	}
+
+	var request s3Delete
+	if err := xml.Unmarshal(body, &request); err != nil {
+		return errS3MalformedXML
+	}
+	if len(request.Objects) == 0 || len(request.Objects) > s3MaxDeleteObjects {
+		return errS3MalformedXML
+	}
+
+	result := s3DeleteResult{Xmlns: s3XMLNamespace}
+	for _, obj := range request.Objects {
+		s.store.delete(bucket + "/" + obj.Key)
+		if !request.Quiet {
+			result.Deleted = append(result.Deleted, struct{ Key string }{obj.Key})
+		}
+	}
+
+	return writeXML(w, result)
+}
+
+// listObjectsV2 answers a ListObjectsV2 request. Continuation tokens are the
+// encoded last key of the previous page. Delimiters and the original
+// ListObjects API are not supported.
+func (s *S3Server) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket string) error {
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" || query.Get("delimiter") != "" {
+		return errS3NotImplemented
+	}
+
+	maxKeys := s3MaxKeys
+	if arg := query.Get("max-keys"); arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return errS3InvalidArgument
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+
+	result := s3ListBucketResult{
+		Xmlns:             s3XMLNamespace,
+		Name:              bucket,
+		Prefix:            query.Get("prefix"),
+		MaxKeys:           maxKeys,
+		ContinuationToken: query.Get("continuation-token"),
+		StartAfter:        query.Get("start-after"),
+	}
+
+	after := result.StartAfter
+	if result.ContinuationToken != "" {
+		key, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			return errS3InvalidArgument
+		}
+		after = string(key)
+	}
+
+	bucketPrefix := bucket + "/"
+	keys, truncated := s.store.list(bucketPrefix+result.Prefix, bucketPrefix+after, maxKeys)
+	for _, key := range keys {
+		obj, ok := s.store.get(key)
+		if !ok {
+			continue
+		}
+		result.Contents = append(result.Contents, s3ListObject{
+			Key:          strings.TrimPrefix(key, bucketPrefix),
+			LastModified: obj.LastModified.UTC().Format(s3TimeFormat),
+			ETag:         obj.ETag,
+			Size:         len(obj.Data),
+			StorageClass: "STANDARD",
+		})
+	}
+
+	result.KeyCount = len(result.Contents)
+	if truncated && len(keys) > 0 {
+		result.IsTruncated = true
+		last := strings.TrimPrefix(keys[len(keys)-1], bucketPrefix)
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+
+	return writeXML(w, result)
+}
+
+func (s *S3Server) handleObject(w http.ResponseWriter, r *http.Request, bucket, key string, body []byte) error {
+	query := r.URL.Query()
+	_, isInitiate := query["uploads"]
+	uploadID := query.Get("uploadId")
+
+	switch {
+	case r.Method == http.MethodPost && isInitiate:
+		return s.initiateMultipartUpload(w, bucket, key)
+	case r.Method == http.MethodPut && uploadID != "":
+		return s.uploadPart(w, r, bucket, key, uploadID, body)
+	case r.Method == http.MethodPost && uploadID != "":
+		return s.completeMultipartUpload(w, bucket, key, uploadID, body)
+	case r.Method == http.MethodDelete && uploadID != "":
+		return s.abortMultipartUpload(w, uploadID)
+	}
+
+	objectKey := bucket + "/" + key
+
+	switch r.Method {
+	case http.MethodPut:
+		if copySource := r.Header.Get("x-amz-copy-source"); copySource != "" {
+			return s.copyObject(w, copySource, objectKey)
+		}
+		obj := &memObject{
+			Data:         body,
+			ETag:         s3ETag(body),
+			LastModified: time.Now().UTC(),
+		}
+		s.store.put(objectKey, obj)
+		w.Header().Set("ETag", obj.ETag)
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodGet, http.MethodHead:
+		obj, ok := s.store.get(objectKey)
+		if !ok {
+			return errS3NoSuchKey
+		}
+		return s.writeObject(w, r, obj)
+	case http.MethodDelete:
+		s.store.delete(objectKey)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	default:
+		return errS3MethodNotAllowed
+	}
+}
+
+// writeObject answers a GET or HEAD, honoring single byte ranges
+func (s *S3Server) writeObject(w http.ResponseWriter, r *http.Request, obj *memObject) error {
+	size := int64(len(obj.Data))
+	start, end := int64(0), size-1
+	status := http.StatusOK
+
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		var err error
+		start, end, err = parseRange(rangeHeader, size)
+		if err != nil {
+			return err
+		}
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.Header().Set("ETag", obj.ETag)
+	w.Header().Set("Last-Modified", obj.LastModified.Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(obj.Data[start : end+1])
+	}
+	return nil
+}
+
+// parseRange parses a "bytes=" range header with a single range, returning
+// the inclusive bounds of the range
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, errS3InvalidRange
+	}
+
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return 0, 0, errS3InvalidRange
+	}
+
+	first, last := spec[:dash], spec[dash+1:]
+	var start, end int64
+	var err error
+
+	if first == "" {
+		// suffix range: the last N bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, errS3InvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		start, end = size-n, size-1
+	} else {
+		if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+			return 0, 0, errS3InvalidRange
+		}
+		end = size - 1
+		if last != "" {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+				return 0, 0, errS3InvalidRange
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+	}
+
+	if start >= size || start < 0 {
+		return 0, 0, errS3InvalidRange
+	}
+	return start, end, nil
+}
+
+func (s *S3Server) initiateMultipartUpload(w http.ResponseWriter, bucket, key string) error {
+	uploadID := fmt.Sprintf("%x-%x", time.Now().UnixNano(), atomic.AddUint64(&s.requestID, 1))
+
+	s.mu.Lock()
+	s.uploads[uploadID] = &s3MultipartUpload{
+		Bucket: bucket,
+		Key:    key,
+		parts:  make(map[int]*memObject),
+	}
+	s.mu.Unlock()
+
+	return writeXML(w, s3InitiateMultipartUploadResult{
+		Xmlns:    s3XMLNamespace,
+		Bucket:   bucket,
+		Key:      key,
+		UploadId: uploadID,
+	})
+}
+
+func (s *S3Server) multipartUpload(bucket, key, uploadID string) (*s3MultipartUpload, error) {
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	s.mu.Unlock()
+
+	if !ok || upload.Bucket != bucket || upload.Key != key {
+		return nil, errS3NoSuchUpload
+	}
+	return upload, nil
+}
+
+// copySourceObject returns the object named by a x-amz-copy-source header
+func (s *S3Server) copySourceObject(copySource string) (*memObject, error) {
+	source, err := url.PathUnescape(copySource)
+	if err != nil {
+		return nil, errS3InvalidArgument
+	}
+	if strings.Contains(source, "?versionId=") {
+		return nil, errS3NotImplemented
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errS3InvalidArgument
+	}
+	if !s.bucketExists(parts[0]) {
+		return nil, errS3NoSuchBucket
+	}
+
+	obj, ok := s.store.get(parts[0] + "/" + parts[1])
+	if !ok {
+		return nil, errS3NoSuchKey
+	}
+	return obj, nil
+}
+
+// copyObject answers a CopyObject request. Objects are never modified, so
+// the copy shares the data of its source.
+func (s *S3Server) copyObject(w http.ResponseWriter, copySource, objectKey string) error {
+	src, err := s.copySourceObject(copySource)
+	if err != nil {
+		return err
+	}
+
+	obj := &memObject{
+		Data:         src.Data,
+		ETag:         src.ETag,
+		LastModified: time.Now().UTC(),
+	}
+	s.store.put(objectKey, obj)
+
+	return writeXML(w, s3CopyObjectResult{
+		Xmlns:        s3XMLNamespace,
+		LastModified: obj.LastModified.Format(s3TimeFormat),
+		ETag:         obj.ETag,
+	})
+}
+
+func (s *S3Server) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string, body []byte) error {
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		return errS3InvalidArgument
+	}
+
+	upload, err := s.multipartUpload(bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	// UploadPartCopy
+	copySource := r.Header.Get("x-amz-copy-source")
+	if copySource != "" {
+		src, err := s.copySourceObject(copySource)
+		if err != nil {
+			return err
+		}
+
+		body = src.Data
+		if copyRange := r.Header.Get("x-amz-copy-source-range"); copyRange != "" {
+			start, end, err := parseRange(copyRange, int64(len(src.Data)))
+			if err != nil {
+				return errS3InvalidArgument
+			}
+			body = src.Data[start : end+1]
+		}
+	}
+
+	part := &memObject{Data: body, ETag: s3ETag(body)}
+	upload.mu.Lock()
+	upload.parts[partNumber] = part
+	upload.mu.Unlock()
+
+	if copySource != "" {
+		return writeXML(w, s3CopyPartResult{
+			Xmlns:        s3XMLNamespace,
+			LastModified: time.Now().UTC().Format(s3TimeFormat),
+			ETag:         part.ETag,
+		})
+	}
+
+	w.Header().Set("ETag", part.ETag)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *S3Server) completeMultipartUpload(w http.ResponseWriter, bucket, key, uploadID string, body []byte) error {
+	upload, err := s.multipartUpload(bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	var complete s3CompleteMultipartUpload
+	if err := xml.Unmarshal(body, &complete); err != nil || len(complete.Parts) == 0 {
+		return errS3MalformedXML
+	}
+
+	var data []byte
+	md5s := make([]byte, 0, md5.Size*len(complete.Parts))
+
+	upload.mu.Lock()
+	for i, p := range complete.Parts {
+		if i > 0 && p.PartNumber <= complete.Parts[i-1].PartNumber {
+			upload.mu.Unlock()
+			return errS3InvalidPartOrder
+		}
+
+		part, ok := upload.parts[p.PartNumber]
+		if !ok || strings.Trim(p.ETag, `"`) != strings.Trim(part.ETag, `"`) {
+			upload.mu.Unlock()
+			return errS3InvalidPart
+		}
+
+		data = append(data, part.Data...)
+		sum, _ := hex.DecodeString(strings.Trim(part.ETag, `"`))
+		md5s = append(md5s, sum...)
+	}
+	upload.mu.Unlock()
+
+	s.mu.Lock()
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+
+	sum := md5.Sum(md5s)
+	etag := fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(complete.Parts))
+	s.store.put(bucket+"/"+key, &memObject{
+		Data:         data,
+		ETag:         etag,
+		LastModified: time.Now().UTC(),
+	})
+
+	return writeXML(w, s3CompleteMultipartUploadResult{
+		Xmlns:    s3XMLNamespace,
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     etag,
+	})
+}
+
+func (s *S3Server) abortMultipartUpload(w http.ResponseWriter, uploadID string) error {
+	s.mu.Lock()
+	_, ok := s.uploads[uploadID]
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+
+	if !ok {
+		return errS3NoSuchUpload
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) error {
+	out, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(out)
+	return nil
+}
+
+func s3ETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// authenticate verifies the SigV2 or SigV4 signature of the request
+func (s *S3Server) authenticate(r *http.Request, body []byte) error {
+	if s.AccessKey == "" {
+		return nil
+	}
+
+	auth := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(auth, "AWS4-HMAC-SHA256 "):
+		return s.verifySigV4(r, auth, body)
+	case strings.HasPrefix(auth, "AWS "):
+		return s.verifySigV2(r, auth)
+	default:
+		return errS3AccessDenied
+	}
+}
+
+func (s *S3Server) verifySigV2(r *http.Request, auth string) error {
+	credentials := strings.SplitN(strings.TrimPrefix(auth, "AWS "), ":", 2)
+	if len(credentials) != 2 {
+		return errS3AccessDenied
+	}
+	if credentials[0] != s.AccessKey {
+		return errS3InvalidAccessKeyID
+	}
+
+	// The Date header is replaced by x-amz-date, which is signed among the
+	// amz headers, when present
+	date := r.Header.Get("Date")
+	requestTime, err := http.ParseTime(date)
+	if amzDate := r.Header.Get("X-Amz-Date"); amzDate != "" {
+		date = ""
+		requestTime, err = parseAmzDate(amzDate)
+	}
+	if err != nil {
+		return errS3AccessDenied
+	}
+	if err := checkClockSkew(requestTime); err != nil {
+		return err
+	}
+
+	stringToSign := r.Method + "\n" + r.Header.Get("Content-MD5") + "\n" + r.Header.Get("Content-Type") + "\n" +
+		date + "\n" + sigV2CanonicalAmzHeaders(r) + sigV2CanonicalResource(r)
+
+	mac := hmac.New(sha1.New, []byte(s.SecretKey))
+	mac.Write([]byte(stringToSign))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(credentials[1])) {
+		return errS3SignatureMismatch
+	}
+	return nil
+}
+
+// Query parameters which are part of the canonicalized resource of SigV2,
+// sorted. The server keeps its own list and canonicalization, independent of the
+// client, so that a signing bug of the client is caught.
+var sigV2SubResources = []string{
+	"acl", "delete", "lifecycle", "location", "logging", "notification", "partNumber", "policy",
+	"requestPayment", "response-cache-control", "response-content-disposition",
+	"response-content-encoding", "response-content-language", "response-content-type",
+	"response-expires", "tagging", "torrent", "uploadId", "uploads", "versionId", "versioning",
+	"versions", "website",
+}
+
+// sigV2CanonicalAmzHeaders returns the x-amz- headers, lowercased and sorted,
+// with the values of repeated headers joined by commas, one per line
+func sigV2CanonicalAmzHeaders(r *http.Request) string {
+	headers := make(map[string][]string)
+	var names []string
+	for name, values := range r.Header {
+		lower := strings.ToLower(name)
+		if !strings.HasPrefix(lower, "x-amz-") {
+			continue
+		}
+		if _, ok := headers[lower]; !ok {
+			names = append(names, lower)
+		}
+		for _, v := range values {
+			// Folded values are unfolded
+			headers[lower] = append(headers[lower], strings.Join(strings.Fields(v), " "))
+		}
+	}
+	sort.Strings(names)
+
+	var buf strings.Builder
+	for _, name := range names {
+		buf.WriteString(name + ":" + strings.Join(headers[name], ",") + "\n")
+	}
+	return buf.String()
+}
+
+// sigV2CanonicalResource returns the path of the request, with the bucket
+// since requests are path-style, followed by the signed subresources in
+// lexicographical order
+func sigV2CanonicalResource(r *http.Request) string {
+	query := r.URL.Query()
+
+	var params []string
+	for _, name := range sigV2SubResources {
+		values, ok := query[name]
+		if !ok {
+			continue
+		}
+		if len(values) == 0 || values[0] == "" {
+			params = append(params, name)
+		} else {
+			params = append(params, name+"="+values[0])
+		}
+	}
+
+	resource := r.URL.EscapedPath()
+	if len(params) > 0 {
+		resource += "?" + strings.Join(params, "&")
+	}
+	return resource
+}
+
+func (s *S3Server) verifySigV4(r *http.Request, auth string, body []byte) error {
+	var credential, signedHeaders, signature string
+	for _, field := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return errS3AccessDenied
+		}
+		switch kv[0] {
+		case "Credential":
+			credential = kv[1]
+		case "SignedHeaders":
+			signedHeaders = kv[1]
+		case "Signature":
+			signature = kv[1]
+		}
+	}
+
+	// Credential is ACCESS_KEY/DATE/REGION/SERVICE/aws4_request
+	scope := strings.Split(credential, "/")
+	if len(scope) != 5 || scope[4] != "aws4_request" {
+		return errS3AccessDenied
+	}
+	if scope[0] != s.AccessKey {
+		return errS3InvalidAccessKeyID
+	}
+	if s.Region != "" && scope[2] != s.Region {
+		return &s3Error{http.StatusBadRequest, "AuthorizationHeaderMalformed",
+			fmt.Sprintf("the region '%s' is wrong; expecting '%s'", scope[2], s.Region)}
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	requestTime, err := parseAmzDate(amzDate)
+	if err != nil {
+		return errS3AccessDenied
+	}
+	if err := checkClockSkew(requestTime); err != nil {
+		return err
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	switch payloadHash {
+	case "UNSIGNED-PAYLOAD":
+	case "STREAMING-AWS4-HMAC-SHA256-PAYLOAD":
+		return errS3NotImplemented
+	case "":
+		payloadHash = hex.EncodeToString(sha256Sum(body))
+	default:
+		if payloadHash != hex.EncodeToString(sha256Sum(body)) {
+			return errS3ContentSHA256Mismatch
+		}
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		canonicalHeaders.WriteString(name + ":" + canonicalHeaderValue(r, name) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		awsURIEscape(r.URL.Path, false),
+		canonicalQueryString(r.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		strings.Join(scope[1:], "/"),
+		hex.EncodeToString(sha256Sum([]byte(canonicalRequest))),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), scope[1])
+	key = hmacSHA256(key, scope[2])
+	key = hmacSHA256(key, scope[3])
+	key = hmacSHA256(key, "aws4_request")
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errS3SignatureMismatch
+	}
+	return nil
+}
+
+func canonicalHeaderValue(r *http.Request, name string) string {
+	switch name {
+	case "host":
+		return r.Host
+	case "content-length":
+		if r.Header.Get("Content-Length") == "" {
+			return strconv.FormatInt(r.ContentLength, 10)
+		}
+	}
+
+	values := r.Header[http.CanonicalHeaderKey(name)]
+	for n, v := range values {
+		values[n] = strings.Join(strings.Fields(v), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+func canonicalQueryString(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, awsURIEscape(name, true)+"="+awsURIEscape(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// awsURIEscape percent-encodes everything but the unreserved characters,
+// and the slashes unless encodeSlash is set
+func awsURIEscape(s string, encodeSlash bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func parseAmzDate(date string) (time.Time, error) {
+	return time.Parse("20060102T150405Z", date)
+}
+
+func checkClockSkew(t time.Time) error {
+	skew := time.Since(t)
+	if skew > s3MaxClockSkew || skew < -s3MaxClockSkew {
+		return errS3TimeTooSkewed
+	}
+	return nil
+}
+
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func hmacSHA256(key []byte, content string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(content))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,358 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// Kinds of object size distributions
+const (
+	sizeFixed     = "fixed"
+	sizeWeighted  = "weighted"
+	sizeUniform   = "uniform"
+	sizeLogNormal = "lognormal"
+)
+
+// Without an explicit maximum, log-normal sizes are capped to this many
+// times the median
+const logNormalDefaultMaxFactor = 64
+
+// sizeDistribution picks the size of every object. The size is derived from
+// the object id, so that it is known again when downloading the object.
+type sizeDistribution struct {
+	Kind string
+
+	// fixed and weighted
+	Sizes   []uint64
+	Weights []int
+
+	// uniform and lognormal
+	Min uint64
+	Max uint64
+
+	// lognormal
+	Median float64
+	Sigma  float64
+}
+
+var objectSizes = &sizeDistribution{Kind: sizeFixed, Sizes: []uint64{1024 * 1024}, Weights: []int{1}}
+
+// Size distribution given by -z, or by the current point of a size sweep.
+// The PUT phases of a workload file may upload objects of other sizes.
+var baseObjectSizes = objectSizes
+
+// sizeEpoch records the size distribution of the objects with ids below
+// before, uploaded before the distribution changed
+type sizeEpoch struct {
+	before int
+	sizes  *sizeDistribution
+}
+
+// Previous size distributions of the objects of the loop, oldest first
+var previousSizes []sizeEpoch
+
+// objectSize returns the size of object id
+func objectSize(id int) uint64 {
+	for _, e := range previousSizes {
+		if id < e.before {
+			return e.sizes.sizeFor(id)
+		}
+	}
+	return objectSizes.sizeFor(id)
+}
+
+// setBaseObjectSizes sets the size distribution of all the objects
+func setBaseObjectSizes(sizes *sizeDistribution) {
+	baseObjectSizes = sizes
+	objectSizes = sizes
+	object_size = sizes.maxSize()
+	previousSizes = nil
+}
+
+// changeObjectSizes sets the size distribution of the objects uploaded from
+// now on, keeping the one of the objects already uploaded
+func changeObjectSizes(sizes *sizeDistribution) {
+	if sizes == objectSizes {
+		return
+	}
+	if next := nextObjectID(); next > 0 {
+		previousSizes = append(previousSizes, sizeEpoch{before: next, sizes: objectSizes})
+	}
+	objectSizes = sizes
+	object_size = sizes.maxSize()
+}
+
+// parseSizeDistribution parses the -z argument, which is one of:
+//
+//	1M                  a fixed size
+//	4K:70,1M:25,1G:5    sizes with their weights
+//	4K-1M               sizes uniformly distributed in a range
+//	lognormal:1M:1.5    log-normal sizes with a median and a sigma,
+//	                    optionally followed by :MAX
+func parseSizeDistribution(arg string) (*sizeDistribution, error) {
+	switch {
+	case strings.HasPrefix(arg, sizeLogNormal+":"):
+		params := strings.Split(strings.TrimPrefix(arg, sizeLogNormal+":"), ":")
+		if len(params) < 2 || len(params) > 3 {
+			return nil, fmt.Errorf("expected lognormal:MEDIAN:SIGMA[:MAX]")
+		}
+
+		median, err := bytefmt.ToBytes(params[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid median %q: %v", params[0], err)
+		}
+		sigma, err := strconv.ParseFloat(params[1], 64)
+		if err != nil || sigma <= 0 {
+			return nil, fmt.Errorf("invalid sigma %q", params[1])
+		}
+
+		max := median * logNormalDefaultMaxFactor
+		if len(params) == 3 {
+			if max, err = bytefmt.ToBytes(params[2]); err != nil || max < median {
+				return nil, fmt.Errorf("invalid maximum %q", params[2])
+			}
+		}
+
+		return &sizeDistribution{
+			Kind:   sizeLogNormal,
+			Min:    1,
+			Max:    max,
+			Median: float64(median),
+			Sigma:  sigma,
+		}, nil
+
+	case strings.Contains(arg, ":") || strings.Contains(arg, ","):
+		d := &sizeDistribution{Kind: sizeWeighted}
+		for _, field := range strings.Split(arg, ",") {
+			kv := strings.SplitN(field, ":", 2)
+
+			size, err := bytefmt.ToBytes(kv[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid size %q: %v", kv[0], err)
+			}
+
+			weight := 1
+			if len(kv) == 2 {
+				if weight, err = strconv.Atoi(kv[1]); err != nil || weight <= 0 {
+					return nil, fmt.Errorf("invalid weight %q for size %s", kv[1], kv[0])
+				}
+			}
+
+			d.Sizes = append(d.Sizes, size)
+			d.Weights = append(d.Weights, weight)
+		}
+		return d, nil
+
+	case strings.Contains(arg, "-"):
+		bounds := strings.SplitN(arg, "-", 2)
+		min, err := bytefmt.ToBytes(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid minimum %q: %v", bounds[0], err)
+		}
+		max, err := bytefmt.ToBytes(bounds[1])
+		if err != nil || max < min {
+			return nil, fmt.Errorf("invalid maximum %q", bounds[1])
+		}
+		return &sizeDistribution{Kind: sizeUniform, Min: min, Max: max}, nil
+
+	default:
+		size, err := bytefmt.ToBytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &sizeDistribution{Kind: sizeFixed, Sizes: []uint64{size}, Weights: []int{1}}, nil
+	}
+}
+
+func (d *sizeDistribution) fixed() bool {
+	return d.Kind == sizeFixed
+}
+
+// maxSize returns the largest size an object can have
+func (d *sizeDistribution) maxSize() uint64 {
+	switch d.Kind {
+	case sizeUniform, sizeLogNormal:
+		return d.Max
+	}
+
+	var max uint64
+	for _, size := range d.Sizes {
+		if size > max {
+			max = size
+		}
+	}
+	return max
+}
+
+// uniform returns a number in [0, 1) derived from the object id and the n-th
+// draw for it
+func (d *sizeDistribution) uniform(id int, n uint64) float64 {
+	x := splitmix64(uint64(payloadSeed) ^ splitmix64(uint64(id)*4+n))
+	return float64(x>>11) / (1 << 53)
+}
+
+func (d *sizeDistribution) sizeFor(id int) uint64 {
+	switch d.Kind {
+	case sizeFixed:
+		return d.Sizes[0]
+
+	case sizeWeighted:
+		total := 0
+		for _, w := range d.Weights {
+			total += w
+		}
+
+		n := int(d.uniform(id, 0) * float64(total))
+		for i, w := range d.Weights {
+			if n < w {
+				return d.Sizes[i]
+			}
+			n -= w
+		}
+		return d.Sizes[len(d.Sizes)-1]
+
+	case sizeUniform:
+		return d.Min + uint64(d.uniform(id, 0)*float64(d.Max-d.Min+1))
+
+	default:
+		// Box-Muller transform of two uniform numbers to a normal one
+		u1, u2 := d.uniform(id, 0), d.uniform(id, 1)
+		z := math.Sqrt(-2*math.Log(1-u1)) * math.Cos(2*math.Pi*u2)
+
+		size := d.Median * math.Exp(d.Sigma*z)
+		if size < float64(d.Min) {
+			return d.Min
+		}
+		if size > float64(d.Max) {
+			return d.Max
+		}
+		return uint64(size)
+	}
+}
+
+// bucket returns the upper bound of the size bucket statistics are grouped
+// in: the exact size for weighted sizes, else the next power of two
+func (d *sizeDistribution) bucket(size uint64) uint64 {
+	if d.Kind == sizeWeighted || size <= 1 {
+		return size
+	}
+	return 1 << uint(bits.Len64(size-1))
+}
+
+func (d *sizeDistribution) String() string {
+	switch d.Kind {
+	case sizeFixed:
+		return bytefmt.ByteSize(d.Sizes[0])
+	case sizeWeighted:
+		var fields []string
+		for i, size := range d.Sizes {
+			fields = append(fields, fmt.Sprintf("%s:%d", bytefmt.ByteSize(size), d.Weights[i]))
+		}
+		return strings.Join(fields, ",")
+	case sizeUniform:
+		return fmt.Sprintf("%s-%s", bytefmt.ByteSize(d.Min), bytefmt.ByteSize(d.Max))
+	default:
+		return fmt.Sprintf("lognormal:%s:%g:%s",
+			bytefmt.ByteSize(uint64(d.Median)), d.Sigma, bytefmt.ByteSize(d.Max))
+	}
+}
+
+// SizeBucketResult holds the statistics of the objects of a phase whose
+// size is at most MaxSize, and above the MaxSize of the previous bucket
+type SizeBucketResult struct {
+	MaxSize    uint64       `json:"max_size"`
+	Successful int          `json:"successful"`
+	Failed     int          `json:"failed"`
+	Bytes      uint64       `json:"bytes"`
+	MBps       float64      `json:"mbps"`
+	OpsPerSec  float64      `json:"ops_per_sec"`
+	Latency    LatencyStats `json:"latency"`
+}
+
+// newSizeBuckets groups the results of a phase by object size
+func newSizeBuckets(elapsed float64, results []TransferResult) []SizeBucketResult {
+	buckets := make(map[uint64]*SizeBucketResult)
+	durations := make(map[uint64][]float64)
+
+	for _, r := range results {
+		// Ranged reads are grouped by the size of the object they read
+		if r.ObjectSize == 0 {
+			continue
+		}
+
+		bound := objectSizes.bucket(r.ObjectSize)
+		b, ok := buckets[bound]
+		if !ok {
+			b = &SizeBucketResult{MaxSize: bound}
+			buckets[bound] = b
+		}
+
+		if r.Error != nil {
+			b.Failed++
+			continue
+		}
+		b.Successful++
+		b.Bytes += r.Bytes
+		durations[bound] = append(durations[bound], r.Duration.Seconds())
+	}
+
+	sorted := make([]SizeBucketResult, 0, len(buckets))
+	for bound, b := range buckets {
+		sort.Float64s(durations[bound])
+		b.Latency = computeLatencyStats(durations[bound])
+		if elapsed > 0 {
+			b.MBps = (float64(b.Bytes) / elapsed) / (1000 * 1000)
+			b.OpsPerSec = float64(b.Successful) / elapsed
+		}
+		sorted = append(sorted, *b)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].MaxSize < sorted[j].MaxSize
+	})
+	return sorted
+}
+
+func printSizeBuckets(p PhaseResult) {
+	if len(p.SizeBuckets) == 0 {
+		return
+	}
+
+	fmt.Printf("%s by object size:\n", p.Operation)
+	fmt.Printf("  %-10s%-12s%-8s%-9s%-10s%-10s%-10s\n",
+		"Size", "Successful", "Failed", "MBps", "Ops/s", "p50(ms)", "p99(ms)")
+	for _, b := range p.SizeBuckets {
+		label := bytefmt.ByteSize(b.MaxSize)
+		if objectSizes.Kind != sizeWeighted {
+			label = "<=" + label
+		}
+		fmt.Printf("  %-10s%-12d%-8d%-9.2f%-10.2f%-10.2f%-10.2f\n",
+			label, b.Successful, b.Failed, b.MBps, b.OpsPerSec, b.Latency.P50*1000, b.Latency.P99*1000)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestParseSizeDistribution(t *testing.T) {
+	for arg, expected := range map[string]string{
+		"1M":               "1M",
+		"4K:70,1M:25":      "4K:70,1M:25",
+		"4K-1M":            "4K-1M",
+		"lognormal:1M:1.5": "lognormal:1M:1.5:64M",
+	} {
+		d, err := parseSizeDistribution(arg)
+		if err != nil {
+			t.Errorf("%s: %v", arg, err)
+			continue
+		}
+		if d.String() != expected {
+			t.Errorf("%s parsed as %s, expected %s", arg, d, expected)
+		}
+		for id := 0; id < 1000; id++ {
+			if size := d.sizeFor(id); size > d.maxSize() {
+				t.Errorf("%s: object %d of %d bytes, above the maximum", arg, id, size)
+			}
+		}
+	}
+
+	for _, arg := range []string{"", "1X", "1M-4K", "4K:0", "lognormal:1M", "lognormal:1M:1:4K"} {
+		if _, err := parseSizeDistribution(arg); err == nil {
+			t.Errorf("%q accepted", arg)
+		}
+	}
+}
+
+// TestSizeBucketsRanges checks that ranged reads are grouped by the size of
+// the object they read
+func TestSizeBucketsRanges(t *testing.T) {
+	setBaseObjectSizes(&sizeDistribution{Kind: sizeUniform, Min: 1024, Max: 1024 * 1024})
+
+	results := []TransferResult{
+		{Bytes: 4096, ObjectSize: 1000 * 1000},
+		{Bytes: 4096, ObjectSize: 3000},
+	}
+	buckets := newSizeBuckets(1, results)
+	if len(buckets) != 2 || buckets[0].MaxSize != 4096 || buckets[1].MaxSize != 1024*1024 {
+		t.Errorf("buckets %+v, expected <=4K and <=1M", buckets)
+	}
+}
+
+// TestRandomPayloadRepeated checks that the random content is repeated in
+// the objects larger than the shared buffer
+func TestRandomPayloadRepeated(t *testing.T) {
+	payloadMode = payloadRandom
+	compressionRatio = 1
+	setBaseObjectSizes(&sizeDistribution{Kind: sizeFixed, Sizes: []uint64{1 << 30}, Weights: []int{1}})
+	initPayload()
+
+	if len(object_data) != maxPayloadDataSize {
+		t.Fatalf("%d bytes of random content, expected %d", len(object_data), maxPayloadDataSize)
+	}
+
+	payload := newPayload(0)
+	if size, _ := payload.Seek(0, io.SeekEnd); size != 1<<30 {
+		t.Errorf("payload of %d bytes, expected 1G", size)
+	}
+	b := make([]byte, 100)
+	if _, err := payload.(io.ReaderAt).ReadAt(b, 3*maxPayloadDataSize-50); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[:50], object_data[maxPayloadDataSize-50:]) || !bytes.Equal(b[50:], object_data[:50]) {
+		t.Error("the content isn't repeated")
+	}
+}
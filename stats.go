@@ -0,0 +1,188 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Upper bounds of the latency histogram buckets, in seconds. The last
+// bucket catches everything above the previous bound.
+var histogramBounds = []float64{
+	0.001, 0.002, 0.005,
+	0.01, 0.02, 0.05,
+	0.1, 0.2, 0.5,
+	1, 2, 5,
+	10, 20, 50,
+	math.Inf(1),
+}
+
+// LatencyStats summarizes the latency of the successful requests of a phase.
+// All values are in seconds.
+type LatencyStats struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Avg   float64 `json:"avg"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	P999  float64 `json:"p999"`
+	Max   float64 `json:"max"`
+}
+
+type HistogramBucket struct {
+	UpperBound float64
+	Count      int
+}
+
+type jsonHistogramBucket struct {
+	Le    string `json:"le"`
+	Count int    `json:"count"`
+}
+
+// MarshalJSON writes the upper bound as a string since JSON can't represent
+// the infinite bound of the last bucket
+func (b HistogramBucket) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonHistogramBucket{
+		Le:    strconv.FormatFloat(b.UpperBound, 'g', -1, 64),
+		Count: b.Count,
+	})
+}
+
+func (b *HistogramBucket) UnmarshalJSON(data []byte) error {
+	var jb jsonHistogramBucket
+	if err := json.Unmarshal(data, &jb); err != nil {
+		return err
+	}
+
+	bound, err := strconv.ParseFloat(jb.Le, 64)
+	if err != nil {
+		return err
+	}
+
+	b.UpperBound = bound
+	b.Count = jb.Count
+	return nil
+}
+
+// computeLatencyStats expects durations to be sorted in ascending order
+func computeLatencyStats(durations []float64) LatencyStats {
+	stats := LatencyStats{Count: len(durations)}
+	if len(durations) == 0 {
+		return stats
+	}
+
+	var total float64
+	for _, d := range durations {
+		total += d
+	}
+
+	stats.Min = durations[0]
+	stats.Max = durations[len(durations)-1]
+	stats.Avg = total / float64(len(durations))
+	stats.P50 = percentile(durations, 50)
+	stats.P90 = percentile(durations, 90)
+	stats.P99 = percentile(durations, 99)
+	stats.P999 = percentile(durations, 99.9)
+	return stats
+}
+
+// percentile uses the nearest-rank method on a sorted slice
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	// Rounding errors must not push an exact rank to the next one, e.g.
+	// 99.9 / 100 * 1000 is slightly above 999
+	rank := int(math.Ceil(p/100*float64(len(sorted)) - 1e-9))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// computeHistogram expects durations to be sorted in ascending order
+func computeHistogram(durations []float64) []HistogramBucket {
+	buckets := make([]HistogramBucket, len(histogramBounds))
+	for i, bound := range histogramBounds {
+		buckets[i].UpperBound = bound
+	}
+
+	b := 0
+	for _, d := range durations {
+		for d > buckets[b].UpperBound {
+			b++
+		}
+		buckets[b].Count++
+	}
+	return buckets
+}
+
+func printLatencyHeader() {
+	fmt.Printf("%-11s%-10s%-10s%-10s%-10s%-10s%-10s%-10s\n",
+		"Operation", "Min(ms)", "Avg(ms)", "p50(ms)", "p90(ms)", "p99(ms)", "p99.9(ms)", "Max(ms)")
+}
+
+func printLatencyStats(operation string, s LatencyStats) {
+	fmt.Printf("%-11s%-10.2f%-10.2f%-10.2f%-10.2f%-10.2f%-10.2f%-10.2f\n",
+		operation, s.Min*1000, s.Avg*1000, s.P50*1000, s.P90*1000, s.P99*1000, s.P999*1000, s.Max*1000)
+}
+
+// printHistogram only prints the range of buckets that contain samples
+func printHistogram(operation string, buckets []HistogramBucket) {
+	first, last, max := -1, -1, 0
+	for i, b := range buckets {
+		if b.Count == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+
+	if first < 0 {
+		return
+	}
+
+	const barWidth = 40
+
+	fmt.Printf("%s latency histogram:\n", operation)
+	for _, b := range buckets[first : last+1] {
+		label := "+Inf"
+		if !math.IsInf(b.UpperBound, 1) {
+			label = fmt.Sprintf("%gms", b.UpperBound*1000)
+		}
+		bar := strings.Repeat("#", b.Count*barWidth/max)
+		fmt.Printf("  <= %-9s%-9d%s\n", label, b.Count, bar)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	durations := make([]float64, 1000)
+	for i := range durations {
+		durations[i] = float64(i + 1)
+	}
+
+	for p, expected := range map[float64]float64{
+		0:    1,
+		50:   500,
+		90:   900,
+		99:   990,
+		99.9: 999,
+		100:  1000,
+	} {
+		if v := percentile(durations, p); v != expected {
+			t.Errorf("p%g = %g, expected %g", p, v, expected)
+		}
+	}
+
+	// Nearest rank: the p99 of few requests is the slowest one
+	if v := percentile([]float64{1, 2, 3}, 99); v != 3 {
+		t.Errorf("p99 of 3 requests = %g, expected 3", v)
+	}
+	if v := percentile(nil, 50); v != 0 {
+		t.Errorf("p50 of no requests = %g, expected 0", v)
+	}
+
+	stats := computeLatencyStats([]float64{1, 2, 3, 4})
+	if stats.Count != 4 || stats.Min != 1 || stats.Max != 4 || stats.Avg != 2.5 || stats.P50 != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestComputeHistogram(t *testing.T) {
+	buckets := computeHistogram([]float64{0.0005, 0.001, 0.0011, 0.3, 100})
+
+	counts := make(map[float64]int)
+	total := 0
+	for _, b := range buckets {
+		counts[b.UpperBound] = b.Count
+		total += b.Count
+	}
+	if len(buckets) != len(histogramBounds) || total != 5 {
+		t.Fatalf("%d requests in %d buckets, expected 5 in %d", total, len(buckets), len(histogramBounds))
+	}
+	for bound, expected := range map[float64]int{0.001: 2, 0.002: 1, 0.5: 1, math.Inf(1): 1} {
+		if counts[bound] != expected {
+			t.Errorf("%d requests up to %g, expected %d", counts[bound], bound, expected)
+		}
+	}
+
+	// The infinite bound survives the JSON report
+	data, err := json.Marshal(buckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []HistogramBucket
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	last := decoded[len(decoded)-1]
+	if !math.IsInf(last.UpperBound, 1) || last.Count != 1 {
+		t.Errorf("last bucket decoded as %+v", last)
+	}
+}
@@ -0,0 +1,286 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// Thread counts and object sizes the loops are run with in turn, nil to
+// only use -t and -z
+var threadSweep []int
+var sizeSweep []uint64
+
+// A sweep point reaching this fraction of the best throughput of the sweep
+// is considered saturated
+const saturationRatio = 0.9
+
+// sweepPoint is a configuration the loops are run with during a sweep
+type sweepPoint struct {
+	name  string
+	apply func()
+}
+
+// SweepResult holds the statistics of an operation at a point of a sweep,
+// averaged over the loops run there
+type SweepResult struct {
+	Threads    int     `json:"threads"`
+	ObjectSize uint64  `json:"object_size"`
+	Operation  string  `json:"operation"`
+	MBps       float64 `json:"mbps"`
+	OpsPerSec  float64 `json:"ops_per_sec"`
+	P50        float64 `json:"lat_p50"`
+	P99        float64 `json:"lat_p99"`
+	// Set on the first point reaching saturationRatio of the best
+	// throughput of the operation, when sweeping thread counts
+	Saturation bool `json:"saturation,omitempty"`
+}
+
+// parseThreadSweep parses the -t-sweep argument, either a list of thread
+// counts, e.g. 1,8,32, or a range of powers of two, e.g. 1-256
+func parseThreadSweep(arg string) ([]int, error) {
+	if bounds := strings.Split(arg, "-"); len(bounds) == 2 {
+		min, err := strconv.Atoi(bounds[0])
+		if err != nil || min < 1 {
+			return nil, fmt.Errorf("invalid thread count %q", bounds[0])
+		}
+		max, err := strconv.Atoi(bounds[1])
+		if err != nil || max < min {
+			return nil, fmt.Errorf("invalid thread count %q", bounds[1])
+		}
+
+		var counts []int
+		for n := min; n < max; n *= 2 {
+			counts = append(counts, n)
+		}
+		return append(counts, max), nil
+	}
+
+	var counts []int
+	for _, s := range strings.Split(arg, ",") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid thread count %q", s)
+		}
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+// parseSizeSweep parses the -z-sweep argument, either a list of object
+// sizes, e.g. 4K,1M,64M, or a range of sizes doubling from the first one,
+// e.g. 4K-1G
+func parseSizeSweep(arg string) ([]uint64, error) {
+	if bounds := strings.Split(arg, "-"); len(bounds) == 2 {
+		min, err := bytefmt.ToBytes(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid object size %q: %v", bounds[0], err)
+		}
+		// The sizes double from the minimum
+		if min == 0 {
+			return nil, fmt.Errorf("invalid object size %q: must be at least 1 byte", bounds[0])
+		}
+		max, err := bytefmt.ToBytes(bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid object size %q: %v", bounds[1], err)
+		}
+		if max < min {
+			return nil, fmt.Errorf("invalid range %q: %s is less than %s", arg, bounds[1], bounds[0])
+		}
+
+		var sizes []uint64
+		for size := min; size < max; size *= 2 {
+			sizes = append(sizes, size)
+		}
+		return append(sizes, max), nil
+	}
+
+	var sizes []uint64
+	for _, s := range strings.Split(arg, ",") {
+		size, err := bytefmt.ToBytes(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object size %q: %v", s, err)
+		}
+		if size == 0 {
+			return nil, fmt.Errorf("invalid object size %q: must be at least 1 byte", s)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// sweepPoints returns every combination of the swept object sizes and
+// thread counts, or a single point keeping the options as given when not
+// sweeping
+func sweepPoints() []sweepPoint {
+	points := []sweepPoint{{}}
+
+	if sizeSweep != nil {
+		points = points[:0]
+		for _, size := range sizeSweep {
+			size := size
+			points = append(points, sweepPoint{
+				name: bytefmt.ByteSize(size) + " objects",
+				apply: func() {
+					setBaseObjectSizes(&sizeDistribution{Kind: sizeFixed, Sizes: []uint64{size}, Weights: []int{1}})
+				},
+			})
+		}
+	}
+
+	if threadSweep != nil {
+		var combined []sweepPoint
+		for _, p := range points {
+			for _, n := range threadSweep {
+				p, n := p, n
+				name := fmt.Sprintf("%d threads", n)
+				if p.name != "" {
+					name = p.name + ", " + name
+				}
+				combined = append(combined, sweepPoint{
+					name: name,
+					apply: func() {
+						if p.apply != nil {
+							p.apply()
+						}
+						threads = n
+					},
+				})
+			}
+		}
+		points = combined
+	}
+	return points
+}
+
+// newSweepResults averages the phases of the loops run at a sweep point,
+// per operation in the order they ran
+func newSweepResults(loops []LoopResult) []SweepResult {
+	var results []SweepResult
+	index := make(map[string]int)
+	counts := make(map[string]int)
+
+	for _, loop := range loops {
+		for _, phase := range loop.Phases {
+			i, ok := index[phase.Operation]
+			if !ok {
+				i = len(results)
+				index[phase.Operation] = i
+				results = append(results, SweepResult{
+					Threads:    phase.Threads,
+					ObjectSize: phase.ObjectSize,
+					Operation:  phase.Operation,
+				})
+			}
+
+			r := &results[i]
+			r.MBps += phase.MBps
+			r.OpsPerSec += phase.OpsPerSec
+			r.P50 += phase.Latency.P50
+			r.P99 += phase.Latency.P99
+			counts[phase.Operation]++
+		}
+	}
+
+	for i := range results {
+		n := float64(counts[results[i].Operation])
+		results[i].MBps /= n
+		results[i].OpsPerSec /= n
+		results[i].P50 /= n
+		results[i].P99 /= n
+	}
+	return results
+}
+
+// markSaturation flags, for each operation and object size, the first
+// result reaching saturationRatio of its best throughput: adding threads past
+// it brings little more throughput, mostly more latency. Nothing is flagged
+// when only the last result reaches it, the operation did not saturate.
+func markSaturation(results []SweepResult) {
+	type curve struct {
+		operation string
+		size      uint64
+	}
+
+	best := make(map[curve]float64)
+	last := make(map[curve]int)
+	for i, r := range results {
+		c := curve{r.Operation, r.ObjectSize}
+		if r.OpsPerSec > best[c] {
+			best[c] = r.OpsPerSec
+		}
+		last[c] = i
+	}
+
+	marked := make(map[curve]bool)
+	for i, r := range results {
+		c := curve{r.Operation, r.ObjectSize}
+		if marked[c] || best[c] == 0 || r.OpsPerSec < saturationRatio*best[c] {
+			continue
+		}
+		marked[c] = true
+		if i != last[c] {
+			results[i].Saturation = true
+		}
+	}
+}
+
+// printSweepResults prints the results grouped by operation
+func printSweepResults(results []SweepResult) {
+	fmt.Println("\nSweep summary:")
+	fmt.Printf("%-9s%-6s%-11s%-10s%-10s%-10s%-10s\n",
+		"Threads", "Size", "Operation", "MBps", "Ops/s", "p50(ms)", "p99(ms)")
+
+	var operations []string
+	seen := make(map[string]bool)
+	for _, r := range results {
+		if !seen[r.Operation] {
+			seen[r.Operation] = true
+			operations = append(operations, r.Operation)
+		}
+	}
+
+	for _, op := range operations {
+		for _, r := range results {
+			if r.Operation == op {
+				printSweepResult(r)
+			}
+		}
+	}
+}
+
+func printSweepResult(r SweepResult) {
+	size := "var"
+	if r.ObjectSize > 0 {
+		size = bytefmt.ByteSize(r.ObjectSize)
+	}
+
+	fmt.Printf("%-9d%-6s%-11s%-10.2f%-10.0f%-10.2f%-10.2f",
+		r.Threads, size, r.Operation, r.MBps, r.OpsPerSec, r.P50*1000, r.P99*1000)
+	if r.Saturation {
+		fmt.Print("<- saturation")
+	}
+	fmt.Println("")
+}
@@ -0,0 +1,94 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSizeSweep(t *testing.T) {
+	for arg, expected := range map[string][]uint64{
+		"4K,1M":  {4096, 1024 * 1024},
+		"4K-16K": {4096, 8192, 16384},
+		"3K-16K": {3072, 6144, 12288, 16384},
+		"1K-1K":  {1024},
+	} {
+		sizes, err := parseSizeSweep(arg)
+		if err != nil || !reflect.DeepEqual(sizes, expected) {
+			t.Errorf("%s: %v %v, expected %v", arg, sizes, err, expected)
+		}
+	}
+
+	// A range from 0 would never end
+	for _, arg := range []string{"0-1M", "0.5B-1K", "0.5B,1K", "1M-4K", "4K,X"} {
+		if sizes, err := parseSizeSweep(arg); err == nil {
+			t.Errorf("%s accepted: %v", arg, sizes)
+		}
+	}
+}
+
+// TestSizeSweepVerify checks that the objects uploaded again with another
+// size at each point of the sweep are not reported as corrupted
+func TestSizeSweepVerify(t *testing.T) {
+	args := []string{"-protocol", "mem", "-b", "test", "-d", "1", "-z-sweep", "4K,8K",
+		"-payload", "unique", "-seed", "1", "-verify"}
+	report := runBenchmark(args, configure(args))
+
+	if len(report.Loops) != 2 {
+		t.Fatalf("%d loops, expected one per size", len(report.Loops))
+	}
+	for _, loop := range report.Loops {
+		for _, phase := range loop.Phases {
+			if phase.Successful == 0 || phase.Failed > 0 || phase.Corrupted > 0 {
+				t.Errorf("loop %d, %s of %d bytes: %d successful, %d failed, %d corrupted",
+					loop.Loop, phase.Operation, phase.ObjectSize, phase.Successful, phase.Failed, phase.Corrupted)
+			}
+		}
+	}
+}
+
+func TestMarkSaturation(t *testing.T) {
+	results := []SweepResult{
+		{Threads: 1, ObjectSize: 4096, Operation: "PUT", OpsPerSec: 100},
+		{Threads: 2, ObjectSize: 4096, Operation: "PUT", OpsPerSec: 190},
+		{Threads: 4, ObjectSize: 4096, Operation: "PUT", OpsPerSec: 370},
+		{Threads: 8, ObjectSize: 4096, Operation: "PUT", OpsPerSec: 400},
+		// Still growing at the last thread count: not saturated
+		{Threads: 1, ObjectSize: 4096, Operation: "GET", OpsPerSec: 100},
+		{Threads: 2, ObjectSize: 4096, Operation: "GET", OpsPerSec: 200},
+		{Threads: 4, ObjectSize: 4096, Operation: "GET", OpsPerSec: 400},
+		// Another object size is another curve
+		{Threads: 1, ObjectSize: 8192, Operation: "PUT", OpsPerSec: 95},
+		{Threads: 2, ObjectSize: 8192, Operation: "PUT", OpsPerSec: 100},
+	}
+	markSaturation(results)
+
+	var saturated []int
+	for i, r := range results {
+		if r.Saturation {
+			saturated = append(saturated, i)
+		}
+	}
+	if expected := []int{2, 7}; !reflect.DeepEqual(saturated, expected) {
+		t.Errorf("saturation marked at %v, expected %v", saturated, expected)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// targetResult holds the statistics of a target, averaged over its loops
+type targetResult struct {
+	name    string
+	results []SweepResult
+}
+
+// runTargets runs the benchmark against each of the targets in turn, with the
+// same options and payload, and compares them
+func runTargets(args []string, targets []string) {
+	var results []targetResult
+	var seedArg []string
+
+	for _, name := range targets {
+		fmt.Printf("\nRunning against target %s\n\n", name)
+
+		targetArgs := append(append([]string{}, args...), "-targets="+name)
+		targetArgs = append(targetArgs, seedArg...)
+		options := configure(targetArgs)
+		// The next targets get the payload of the first one
+		seedArg = []string{fmt.Sprintf("-seed=%d", payloadSeed)}
+
+		if options.outputPath != "" {
+			options.outputPath = targetOutputPath(options.outputPath, name)
+		}
+
+		report := runBenchmark(targetArgs, options)
+		if report.Sweep == nil {
+			report.Sweep = newSweepResults(report.Loops)
+		}
+		results = append(results, targetResult{name: name, results: report.Sweep})
+	}
+
+	printTargetComparison(results)
+}
+
+// targetOutputPath returns the path of the report of a target, adding its
+// name to the path given with -output, e.g. results-aws.json
+func targetOutputPath(path, name string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + name + ext
+}
+
+// printTargetComparison prints the results of the targets side by side, per
+// operation and sweep point
+func printTargetComparison(targets []targetResult) {
+	width := len("Target") + 2
+	for _, t := range targets {
+		if len(t.name)+2 > width {
+			width = len(t.name) + 2
+		}
+	}
+
+	fmt.Println("\nTarget comparison:")
+	fmt.Printf("%-*s%-9s%-6s%-11s%-10s%-10s%-10s%-10s\n",
+		width, "Target", "Threads", "Size", "Operation", "MBps", "Ops/s", "p50(ms)", "p99(ms)")
+
+	type point struct {
+		operation string
+		threads   int
+		size      uint64
+	}
+
+	var operations []string
+	var points []point
+	seen := make(map[point]bool)
+	seenOperation := make(map[string]bool)
+	for _, t := range targets {
+		for _, r := range t.results {
+			p := point{r.Operation, r.Threads, r.ObjectSize}
+			if !seen[p] {
+				seen[p] = true
+				points = append(points, p)
+			}
+			if !seenOperation[r.Operation] {
+				seenOperation[r.Operation] = true
+				operations = append(operations, r.Operation)
+			}
+		}
+	}
+
+	for _, op := range operations {
+		for _, p := range points {
+			if p.operation != op {
+				continue
+			}
+			for _, t := range targets {
+				for _, r := range t.results {
+					if (point{r.Operation, r.Threads, r.ObjectSize}) != p {
+						continue
+					}
+					size := "var"
+					if r.ObjectSize > 0 {
+						size = bytefmt.ByteSize(r.ObjectSize)
+					}
+					fmt.Printf("%-*s%-9d%-6s%-11s%-10.2f%-10.0f%-10.2f%-10.2f\n",
+						width, t.name, r.Threads, size, r.Operation, r.MBps, r.OpsPerSec, r.P50*1000, r.P99*1000)
+				}
+			}
+		}
+	}
+}
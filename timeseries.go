@@ -0,0 +1,194 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Length of the intervals the phases are split in, 0 to disable them
+var reportInterval time.Duration
+
+// IntervalResult holds the statistics of the requests of a phase completed
+// during an interval
+type IntervalResult struct {
+	Start      float64      `json:"start_secs"` // since the start of the phase
+	Time       float64      `json:"time_secs"`
+	Successful int          `json:"successful"`
+	Failed     int          `json:"failed"`
+	Bytes      uint64       `json:"bytes"`
+	MBps       float64      `json:"mbps"`
+	OpsPerSec  float64      `json:"ops_per_sec"`
+	Latency    LatencyStats `json:"latency"`
+	// Used to merge the intervals of the workers in distributed runs
+	End time.Time `json:"-"`
+}
+
+func newIntervalResult(start, length time.Duration, results []TransferResult) IntervalResult {
+	interval := IntervalResult{
+		Start: start.Seconds(),
+		Time:  length.Seconds(),
+	}
+
+	durations := make([]float64, 0, len(results))
+	for _, r := range results {
+		if r.Error != nil {
+			interval.Failed++
+			continue
+		}
+		interval.Successful++
+		interval.Bytes += r.Bytes
+		durations = append(durations, r.Duration.Seconds())
+	}
+	sort.Float64s(durations)
+
+	if interval.Time > 0 {
+		interval.MBps = (float64(interval.Bytes) / interval.Time) / (1000 * 1000)
+		interval.OpsPerSec = float64(interval.Successful) / interval.Time
+	}
+	interval.Latency = computeLatencyStats(durations)
+	return interval
+}
+
+// intervalRecorder splits the results of a phase in intervals by completion
+// time, printing each interval as soon as it ends. The requests started
+// during the warmup are ignored.
+type intervalRecorder struct {
+	operation string
+	start     time.Time
+
+	mu        sync.Mutex
+	pending   []TransferResult
+	intervals []IntervalResult
+
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// startIntervalRecorder starts recording a phase starting now, after the
+// given warmup. Nothing is recorded when intervals are disabled.
+func startIntervalRecorder(operation string, warmup time.Duration) *intervalRecorder {
+	r := &intervalRecorder{
+		operation: operation,
+		start:     time.Now().Add(warmup),
+		done:      make(chan struct{}),
+	}
+
+	if reportInterval > 0 {
+		printIntervalHeader(operation)
+		r.stopped.Add(1)
+		go r.run()
+	}
+	return r
+}
+
+// add records a result, it is safe for concurrent use. The results of the
+// warmup are only counted in the metrics.
+func (r *intervalRecorder) add(res TransferResult) {
+	recordMetrics(r.operation, res)
+
+	if reportInterval == 0 || res.Start.Before(r.start) {
+		return
+	}
+
+	r.mu.Lock()
+	r.pending = append(r.pending, res)
+	r.mu.Unlock()
+}
+
+func (r *intervalRecorder) run() {
+	defer r.stopped.Done()
+
+	select {
+	case <-time.After(time.Until(r.start)):
+	case <-r.done:
+		return
+	}
+
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			end := r.start.Add(time.Duration(len(r.intervals)+1) * reportInterval)
+			r.mu.Unlock()
+			r.closeInterval(end)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// closeInterval records the pending results completed before end as the next
+// interval
+func (r *intervalRecorder) closeInterval(end time.Time) {
+	r.mu.Lock()
+	var completed, later []TransferResult
+	for _, res := range r.pending {
+		if res.Start.Add(res.Duration).Before(end) {
+			completed = append(completed, res)
+		} else {
+			later = append(later, res)
+		}
+	}
+	r.pending = later
+
+	begin := r.start.Add(time.Duration(len(r.intervals)) * reportInterval)
+	interval := newIntervalResult(begin.Sub(r.start), end.Sub(begin), completed)
+	interval.End = end
+	r.intervals = append(r.intervals, interval)
+	r.mu.Unlock()
+
+	printInterval(r.operation, interval)
+}
+
+// stop records the last, partial, interval if it holds results and returns
+// all of them
+func (r *intervalRecorder) stop() []IntervalResult {
+	if reportInterval == 0 {
+		return nil
+	}
+
+	close(r.done)
+	r.stopped.Wait()
+
+	if len(r.pending) > 0 {
+		r.closeInterval(time.Now())
+	}
+	return r.intervals
+}
+
+func printIntervalHeader(operation string) {
+	fmt.Printf("%s every %v:\n", operation, reportInterval)
+	fmt.Printf("  %-11s%-9s%-12s%-8s%-9s%-9s%-10s%-10s\n",
+		"Operation", "Time", "Successful", "Failed", "MBps", "Ops/s", "p50(ms)", "p99(ms)")
+}
+
+func printInterval(operation string, interval IntervalResult) {
+	fmt.Printf("  %-11s%-9.1f%-12d%-8d%-9.2f%-9.0f%-10.2f%-10.2f\n",
+		operation, interval.Start+interval.Time, interval.Successful, interval.Failed, interval.MBps,
+		interval.OpsPerSec, interval.Latency.P50*1000, interval.Latency.P99*1000)
+}
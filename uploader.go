@@ -27,7 +27,16 @@ import (
 
 type Uploader interface {
 	Prepare(bucket string) error
+	DoCopy(ctx context.Context, srcID, dstID int) (result TransferResult)
 	DoDelete(ctx context.Context, id int) error
-	DoDownload(ctx context.Context, id int) (result TransferResult)
+	DoDownload(ctx context.Context, id int, rng byteRange) (result TransferResult)
+	DoHead(ctx context.Context, id int) (result TransferResult)
+	DoList(ctx context.Context, prefix string) (result TransferResult)
 	DoUpload(ctx context.Context, id int, data io.ReadSeeker) (result TransferResult)
 }
+
+// BatchDeleter is implemented by the clients able to delete several objects
+// with a single request. It returns one result per object, in order.
+type BatchDeleter interface {
+	DoBatchDelete(ctx context.Context, ids []int) (results []TransferResult)
+}
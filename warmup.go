@@ -0,0 +1,51 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "time"
+
+// Time during which the time-limited phases run before being measured
+var warmup time.Duration
+
+// phaseDeadline fires at the end of a time-limited phase starting now. A
+// phase limited by its number of objects only has no deadline.
+func phaseDeadline() <-chan time.Time {
+	if duration_secs == 0 && phaseObjects > 0 {
+		return nil
+	}
+	return time.After(warmup + time.Second*time.Duration(duration_secs))
+}
+
+// measuredResults drops the results of the requests started before start,
+// during the warmup
+func measuredResults(results []TransferResult, start time.Time) []TransferResult {
+	if warmup == 0 {
+		return results
+	}
+
+	measured := make([]TransferResult, 0, len(results))
+	for _, r := range results {
+		if !r.Start.Before(start) {
+			measured = append(measured, r)
+		}
+	}
+	return measured
+}
@@ -0,0 +1,400 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Phases of the loops given by the workload file, nil to run the phases
+// selected by the options
+var workloadSteps []phaseStep
+
+// Number of requests after which a phase ends, 0 to only limit it in time
+var phaseObjects int
+
+// workloadTarget is a storage to run the benchmark against
+type workloadTarget struct {
+	// Only set for the targets listed under targets
+	Name     string `yaml:"name,omitempty" json:"name,omitempty"`
+	Protocol string `yaml:"protocol" json:"protocol"`
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+	Region   string `yaml:"region" json:"region"`
+	Bucket   string `yaml:"bucket" json:"bucket"`
+	// Names of the environment variables holding the credentials, which
+	// are better kept out of the file
+	AccessKeyEnv string `yaml:"access_key_env" json:"access_key_env"`
+	SecretKeyEnv string `yaml:"secret_key_env" json:"secret_key_env"`
+	// Any other option, by flag name
+	Options map[string]interface{} `yaml:"options" json:"options"`
+}
+
+// workloadFile describes a benchmark: the storage to test, any other
+// option, and the phases of its loops. The settings of the selected target,
+// if any, override the ones of the file.
+type workloadFile struct {
+	workloadTarget `yaml:",inline"`
+	Targets        []workloadTarget `yaml:"targets" json:"targets"`
+	Phases         []workloadPhase  `yaml:"phases" json:"phases"`
+}
+
+// workloadPhase is a phase of the workload file. Its settings override the
+// options of the run for this phase only, except the size which applies to
+// the objects uploaded by a PUT phase.
+type workloadPhase struct {
+	Operation string `yaml:"operation" json:"operation"`
+	Size      string `yaml:"size,omitempty" json:"size,omitempty"`
+	Threads   int    `yaml:"threads,omitempty" json:"threads,omitempty"`
+	// Seconds, or a duration such as 90s or 5m
+	Duration interface{} `yaml:"duration,omitempty" json:"duration,omitempty"`
+	// Number of requests after which the phase ends, or the number of
+	// objects to list for LIST
+	Objects int `yaml:"objects,omitempty" json:"objects,omitempty"`
+	// Weights of the operations of a MIX phase, as for -mix
+	Mix string `yaml:"mix,omitempty" json:"mix,omitempty"`
+
+	sizes        *sizeDistribution
+	durationSecs int
+	hasDuration  bool
+	// Set when the phase is only limited by its number of requests
+	countOnly bool
+	mixRatios map[string]int
+}
+
+// Operations of the workload file, and the phases running them
+var workloadOperations = map[string]string{
+	"put":    "PUT",
+	"get":    "GET",
+	"head":   "HEAD",
+	"list":   "LIST",
+	"copy":   "COPY",
+	"mix":    "MIX",
+	"delete": "DELETE",
+}
+
+// loadWorkload parses data, the content of the workload file at path. Its
+// settings become the values of the flags which were not given on the command
+// line. It returns the phases of the file and the names of the targets
+// selected by -targets, the settings of the target being applied when a
+// single one is selected.
+func loadWorkload(flags *flag.FlagSet, path string, data []byte) ([]workloadPhase, []string, error) {
+	var err error
+	var w workloadFile
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		// Rejects misspelled keys, as UnmarshalStrict does for YAML
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&w)
+	} else {
+		err = yaml.UnmarshalStrict(data, &w)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	targets, err := selectTargets(w.Targets, flags.Lookup("targets").Value.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := w.apply(flags, explicit); err != nil {
+		return nil, nil, err
+	}
+	if len(targets) == 1 {
+		if err := targets[0].apply(flags, explicit); err != nil {
+			return nil, nil, fmt.Errorf("target %s: %v", targets[0].Name, err)
+		}
+	}
+
+	for i := range w.Phases {
+		p := &w.Phases[i]
+		if err := p.parse(); err != nil {
+			return nil, nil, fmt.Errorf("phase %d: %v", i+1, err)
+		}
+
+		// Options given on the command line override the phases too
+		if explicit["t"] || explicit["t-sweep"] {
+			p.Threads = 0
+		}
+		if explicit["d"] {
+			p.hasDuration = false
+		}
+		if explicit["z"] || explicit["z-sweep"] {
+			p.sizes = nil
+		}
+		p.countOnly = p.Objects > 0 && p.Operation != "LIST" && !p.hasDuration && !explicit["d"]
+	}
+
+	if err := checkWorkloadPhases(w.Phases); err != nil {
+		return nil, nil, err
+	}
+
+	var names []string
+	for _, t := range targets {
+		names = append(names, t.Name)
+	}
+	return w.Phases, names, nil
+}
+
+// selectTargets returns the targets named in arg, a comma separated list, or
+// all the targets when arg is empty
+func selectTargets(targets []workloadTarget, arg string) ([]workloadTarget, error) {
+	byName := make(map[string]workloadTarget)
+	for i, t := range targets {
+		if t.Name == "" || strings.Contains(t.Name, ",") {
+			return nil, fmt.Errorf("target %d: invalid name %q", i+1, t.Name)
+		}
+		if _, ok := byName[t.Name]; ok {
+			return nil, fmt.Errorf("duplicate target %s", t.Name)
+		}
+		byName[t.Name] = t
+	}
+
+	if arg == "" {
+		return targets, nil
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("-targets requires targets in the workload file")
+	}
+
+	var selected []workloadTarget
+	for _, name := range strings.Split(arg, ",") {
+		t, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown target %s", name)
+		}
+		selected = append(selected, t)
+	}
+	return selected, nil
+}
+
+// apply sets the flags which were not given on the command line to the
+// settings of the target
+func (t *workloadTarget) apply(flags *flag.FlagSet, explicit map[string]bool) error {
+	set := func(name, value string) error {
+		if value == "" || explicit[name] {
+			return nil
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", value, name, err)
+		}
+		return nil
+	}
+
+	settings := []struct{ name, value string }{
+		{"protocol", t.Protocol},
+		{"u", t.Endpoint},
+		{"r", t.Region},
+		{"b", t.Bucket},
+	}
+	for _, env := range []struct{ name, variable string }{{"a", t.AccessKeyEnv}, {"s", t.SecretKeyEnv}} {
+		if env.variable == "" {
+			continue
+		}
+		value := os.Getenv(env.variable)
+		if value == "" {
+			return fmt.Errorf("environment variable %s is not set", env.variable)
+		}
+		settings = append(settings, struct{ name, value string }{env.name, value})
+	}
+	for _, s := range settings {
+		if err := set(s.name, s.value); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(t.Options))
+	for name := range t.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" || name == "targets" || flags.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %s", name)
+		}
+		if err := set(name, fmt.Sprint(t.Options[name])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *workloadPhase) parse() error {
+	operation, ok := workloadOperations[strings.ToLower(p.Operation)]
+	if !ok {
+		return fmt.Errorf("unknown operation %q: available: put, get, head, list, copy, mix, delete", p.Operation)
+	}
+	p.Operation = operation
+
+	var err error
+	if p.Size != "" {
+		if operation != "PUT" {
+			return fmt.Errorf("size is only supported by put, the other operations work on the uploaded objects")
+		}
+		if p.sizes, err = parseSizeDistribution(p.Size); err != nil {
+			return fmt.Errorf("invalid size: %v", err)
+		}
+	}
+
+	if p.Threads < 0 {
+		return fmt.Errorf("threads must be positive")
+	}
+
+	if p.Duration != nil {
+		s := fmt.Sprint(p.Duration)
+		if secs, err := strconv.Atoi(s); err == nil {
+			p.durationSecs = secs
+		} else if d, err := time.ParseDuration(s); err == nil {
+			p.durationSecs = int(d.Seconds())
+		} else {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		if p.durationSecs < 1 {
+			return fmt.Errorf("duration must be at least one second")
+		}
+		p.hasDuration = true
+	}
+
+	if p.Objects < 0 {
+		return fmt.Errorf("objects must be positive")
+	}
+	if p.Objects > 0 && operation == "DELETE" {
+		return fmt.Errorf("objects is not supported by delete, which deletes all the objects")
+	}
+
+	if operation == "MIX" {
+		if p.Mix == "" {
+			return fmt.Errorf("mix requires the weights of the operations")
+		}
+		if p.mixRatios, err = parseMix(p.Mix); err != nil {
+			return fmt.Errorf("invalid mix: %v", err)
+		}
+	} else if p.Mix != "" {
+		return fmt.Errorf("mix is only supported by mix")
+	}
+	return nil
+}
+
+// checkWorkloadPhases checks that every phase has objects to work on
+func checkWorkloadPhases(phases []workloadPhase) error {
+	if len(phases) == 0 {
+		return nil
+	}
+
+	uploaded, copied := false, false
+	for i, p := range phases {
+		switch p.Operation {
+		case "PUT":
+			if copied {
+				return fmt.Errorf("phase %d: put can't follow copy", i+1)
+			}
+			uploaded = true
+		case "GET", "HEAD", "MIX", "COPY":
+			if !uploaded && p.Operation != "MIX" {
+				return fmt.Errorf("phase %d: %s requires a put phase before it", i+1, strings.ToLower(p.Operation))
+			}
+			if copied && p.Operation != "COPY" {
+				// The copies are not valid objects for the phases
+				// reading objects
+				return fmt.Errorf("phase %d: %s can't follow copy", i+1, strings.ToLower(p.Operation))
+			}
+			copied = copied || p.Operation == "COPY"
+		case "DELETE":
+			if i != len(phases)-1 {
+				return fmt.Errorf("phase %d: delete must be the last phase", i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// workloadPhaseSteps returns the phases of the loops, adding a DELETE phase
+// to clean up the objects when the workload file does not end with one
+func workloadPhaseSteps(phases []workloadPhase) []phaseStep {
+	var steps []phaseStep
+	for i := range phases {
+		steps = append(steps, phaseStep{operation: phases[i].Operation, workload: &phases[i]})
+	}
+	if len(steps) == 0 || steps[len(steps)-1].operation != "DELETE" {
+		steps = append(steps, phaseStep{operation: "DELETE"})
+	}
+	return steps
+}
+
+// apply sets the options of the run to the settings of the phase, and
+// returns a function restoring them. The sizes of a PUT phase are kept for
+// the phases using the objects it uploads.
+func (p *workloadPhase) apply() (restore func()) {
+	savedThreads, savedDuration := threads, duration_secs
+	savedObjects, savedListObjects := phaseObjects, listObjects
+	savedMix, savedWarmup := mixRatios, warmup
+
+	if p.Threads > 0 {
+		threads = p.Threads
+	}
+	if p.hasDuration {
+		duration_secs = p.durationSecs
+	}
+	if p.Objects > 0 {
+		if p.Operation == "LIST" {
+			listObjects = p.Objects
+		} else {
+			phaseObjects = p.Objects
+		}
+	}
+	if p.countOnly {
+		// The warmup is time-based, it doesn't apply to a phase ending
+		// after its number of requests
+		duration_secs = 0
+		warmup = 0
+	}
+	if p.mixRatios != nil {
+		mixRatios = p.mixRatios
+	}
+	if p.Operation == "PUT" {
+		sizes := p.sizes
+		if sizes == nil {
+			sizes = baseObjectSizes
+		}
+		changeObjectSizes(sizes)
+	}
+
+	return func() {
+		threads, duration_secs = savedThreads, savedDuration
+		phaseObjects, listObjects = savedObjects, savedListObjects
+		mixRatios, warmup = savedMix, savedWarmup
+	}
+}
@@ -0,0 +1,94 @@
+/*
+# rs-benchmark - A utility to benchmark object storages
+# Copyright (C) 2016-2019 RStor Inc (open-source@rstor.io)
+#
+# This file is part of rs-benchmark.
+#
+# rs-benchmark is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# rs-benchmark is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with Copyright Header.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestWorkload(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "rs-benchmark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestWorkloadCountOnly checks that the phases limited by their number of
+// requests end after them
+func TestWorkloadCountOnly(t *testing.T) {
+	path := writeTestWorkload(t, "workload.yaml", `
+protocol: mem
+bucket: test
+phases:
+  - operation: put
+    objects: 50
+  - operation: mix
+    mix: get=70,put=20,delete=10
+    objects: 200
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	// The warmup only applies to the time-limited phases
+	args := []string{"-config", path, "-t", "4", "-warmup", "2s"}
+	report := runBenchmark(args, configure(args))
+
+	requests := make(map[string]int)
+	for _, phase := range report.Loops[0].Phases {
+		requests[phase.Operation] += phase.Successful + phase.Failed
+	}
+	if requests["PUT"] != 50 || report.Loops[0].Phases[0].Time <= 0 {
+		t.Errorf("PUT: %d requests in %.2fs, expected 50", requests["PUT"], report.Loops[0].Phases[0].Time)
+	}
+	mixed := requests["MIX-GET"] + requests["MIX-PUT"] + requests["MIX-DELETE"]
+	if mixed != 200 {
+		t.Errorf("MIX: %d requests, expected 200", mixed)
+	}
+}
+
+func TestWorkloadUnknownKeys(t *testing.T) {
+	for name, content := range map[string]string{
+		"workload.yaml": "protocol: mem\nphases:\n  - operation: put\n    thread: 4\n",
+		"workload.json": `{"protocol": "mem", "phases": [{"operation": "put", "thread": 4}]}`,
+	} {
+		path := writeTestWorkload(t, name, content)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags.String("protocol", "", "")
+		flags.String("targets", "", "")
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := loadWorkload(flags, path, data); err == nil {
+			t.Errorf("%s: misspelled key accepted", name)
+		}
+	}
+}